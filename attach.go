@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultFileBudget is the total characters of attached file content
+	defaultFileBudget = 30000
+
+	// binarySniffBytes is how much of a file is checked for binary content
+	binarySniffBytes = 8000
+)
+
+// attachment is a file attached to the query with --file.
+type attachment struct {
+	Path     string
+	Language string
+	Content  string
+	// Chars is the file's length in characters before truncation
+	Chars     int
+	Truncated bool
+}
+
+// fileLanguages labels attachments by extension. Unknown extensions are
+// attached without a label.
+var fileLanguages = map[string]string{
+	".c":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".h":     "c",
+	".hpp":   "cpp",
+	".html":  "html",
+	".java":  "java",
+	".js":    "javascript",
+	".json":  "json",
+	".kt":    "kotlin",
+	".lua":   "lua",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".sh":    "bash",
+	".sql":   "sql",
+	".swift": "swift",
+	".toml":  "toml",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".xml":   "xml",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".zig":   "zig",
+}
+
+// loadAttachments reads the files matching patterns, skipping binaries, and
+// truncates them to share budget characters.
+func loadAttachments(patterns []string, budget int) ([]attachment, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if budget <= 0 {
+		return nil, fmt.Errorf("invalid value %q for --file-budget\nBudget must be a positive number of characters", fmt.Sprint(budget))
+	}
+
+	paths, err := expandFilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []attachment
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --file: %w", err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("--file %s is a directory\nUse a glob to attach its files, e.g. --file '%s'", path, filepath.Join(path, "*"))
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --file: %w", err)
+		}
+		if isBinary(data) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping binary file %s\n", path)
+			continue
+		}
+
+		content := string(data)
+		attachments = append(attachments, attachment{
+			Path:     path,
+			Language: fileLanguages[strings.ToLower(filepath.Ext(path))],
+			Content:  content,
+			Chars:    utf8.RuneCountInString(content),
+		})
+	}
+
+	applyFileBudget(attachments, budget)
+	return attachments, nil
+}
+
+// expandFilePatterns expands globs, keeping the order given and dropping
+// duplicates. A glob that matches nothing is an error, as a typo would
+// otherwise silently attach nothing.
+func expandFilePatterns(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for --file: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match --file %q", pattern)
+			}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// isBinary reports whether data looks like a binary file: a NUL byte or
+// invalid UTF-8 near the start.
+func isBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > binarySniffBytes {
+		sniff = sniff[:binarySniffBytes]
+		// Do not mistake a multi-byte character cut at the boundary for
+		// invalid UTF-8
+		for i := 0; i < utf8.UTFMax && len(sniff) > 0 && !utf8.Valid(sniff); i++ {
+			sniff = sniff[:len(sniff)-1]
+		}
+	}
+	return bytes.IndexByte(sniff, 0) >= 0 || !utf8.Valid(sniff)
+}
+
+// applyFileBudget shares budget characters between attachments. Small files
+// are kept whole and their unused share goes to the larger ones, which are
+// cut in the middle.
+func applyFileBudget(attachments []attachment, budget int) {
+	order := make([]int, len(attachments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return attachments[order[a]].Chars < attachments[order[b]].Chars
+	})
+
+	remaining := budget
+	for n, i := range order {
+		share := remaining / (len(order) - n)
+		att := &attachments[i]
+		if att.Chars > share {
+			att.Content = truncateMiddle(att.Content, att.Chars, share)
+			att.Truncated = true
+			remaining -= share
+		} else {
+			remaining -= att.Chars
+		}
+	}
+}
+
+// truncateMiddle keeps the first and last limit/2 characters of content,
+// which has chars characters, with a notice in place of the rest. The head
+// of a file usually has its imports and declarations, and the tail of a log
+// has the latest entries.
+func truncateMiddle(content string, chars, limit int) string {
+	runes := []rune(content)
+	head := limit / 2
+	tail := limit - head
+	return fmt.Sprintf("%s\n[... %d characters truncated by kagi ...]\n%s", string(runes[:head]), chars-limit, string(runes[len(runes)-tail:]))
+}
+
+// formatAttachments renders attachments as labelled code blocks to precede
+// the query.
+func formatAttachments(attachments []attachment) string {
+	var b strings.Builder
+	for i, att := range attachments {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		label := att.Path
+		if att.Language != "" {
+			label += " (" + att.Language + ")"
+		}
+		if att.Truncated {
+			label += fmt.Sprintf(", truncated from %d characters", att.Chars)
+		}
+		fence := codeFence(att.Content)
+		fmt.Fprintf(&b, "File: %s\n%s%s\n%s\n%s", label, fence, att.Language, strings.TrimRight(att.Content, "\n"), fence)
+	}
+	return b.String()
+}
+
+// codeFence returns a backtick fence longer than any run of backticks in
+// content, so the content cannot close it.
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
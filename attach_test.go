@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// writeFiles creates files in a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadAttachments(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.go":  "package main\n",
+		"util.go":  "package util\n",
+		"app.log":  "started\n",
+		"logo.png": "\x89PNG\r\n\x1a\n\x00\x00",
+	})
+
+	files, err := loadAttachments([]string{
+		filepath.Join(dir, "*.go"),
+		filepath.Join(dir, "main.go"),
+		filepath.Join(dir, "app.log"),
+		filepath.Join(dir, "logo.png"),
+	}, defaultFileBudget)
+	if err != nil {
+		t.Fatalf("loadAttachments failed: %v", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file.Path))
+	}
+	if strings.Join(names, ",") != "main.go,util.go,app.log" {
+		t.Errorf("Attached %v; want globs expanded, duplicates dropped and binaries skipped", names)
+	}
+	if files[0].Language != "go" || files[2].Language != "" {
+		t.Errorf("Languages = %q, %q", files[0].Language, files[2].Language)
+	}
+}
+
+func TestLoadAttachmentsErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		pattern string
+		budget  int
+		wantErr string
+	}{
+		{"glob without matches", filepath.Join(dir, "*.go"), defaultFileBudget, "no files match"},
+		{"missing file", filepath.Join(dir, "missing.go"), defaultFileBudget, "failed to read --file"},
+		{"directory", dir, defaultFileBudget, "is a directory"},
+		{"zero budget", filepath.Join(dir, "x"), 0, "--file-budget"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadAttachments([]string{tt.pattern}, tt.budget)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadAttachments() error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyFileBudget(t *testing.T) {
+	attachments := []attachment{
+		{Path: "big.log", Content: strings.Repeat("a", 1000), Chars: 1000},
+		{Path: "small.go", Content: "package x", Chars: 9},
+	}
+	applyFileBudget(attachments, 109)
+
+	if attachments[1].Truncated || attachments[1].Content != "package x" {
+		t.Errorf("Small file should be kept whole: %+v", attachments[1])
+	}
+	big := attachments[0]
+	if !big.Truncated {
+		t.Fatalf("Large file should be truncated")
+	}
+	if !strings.HasPrefix(big.Content, strings.Repeat("a", 50)+"\n[... 900 characters truncated by kagi ...]\n") {
+		t.Errorf("Expected head, notice and tail, got %q", big.Content)
+	}
+	if big.Chars != 1000 {
+		t.Errorf("Chars should record the original length, got %d", big.Chars)
+	}
+}
+
+func TestTruncateMiddleMultibyte(t *testing.T) {
+	content := strings.Repeat("é", 10)
+	got := truncateMiddle(content, 10, 4)
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, "éé\n") || !strings.HasSuffix(got, "\néé") {
+		t.Errorf("truncateMiddle() = %q; want whole characters kept", got)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"text", []byte("hello\n"), false},
+		{"utf-8", []byte("héllo wörld"), false},
+		{"nul byte", []byte("a\x00b"), true},
+		{"invalid utf-8", []byte{0xff, 0xfe, 'a'}, true},
+		{"character cut at sniff boundary", []byte(strings.Repeat("a", binarySniffBytes-1) + "é"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBinary(tt.data); got != tt.want {
+				t.Errorf("isBinary() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAttachments(t *testing.T) {
+	got := formatAttachments([]attachment{
+		{Path: "main.go", Language: "go", Content: "package main\n", Chars: 13},
+		{Path: "README.md", Language: "markdown", Content: "```sh\nmake\n```\n", Chars: 15, Truncated: true},
+	})
+	want := "File: main.go (go)\n```go\npackage main\n```\n\n" +
+		"File: README.md (markdown), truncated from 15 characters\n````markdown\n```sh\nmake\n```\n````"
+	if got != want {
+		t.Errorf("formatAttachments() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLoadConfigAttachmentsOnlyInRequest(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.go": "package main\n\nfunc secretHelper() {}\n"})
+	t.Chdir(dir)
+	t.Setenv(envAPIKey, "test-key")
+
+	previousFiles, previousStdin, previousOutput := flagFiles, flagStdin, flagOutput
+	flagFiles, flagStdin, flagOutput = []string{"main.go"}, stdinIgnore, outputAuto
+	defer func() { flagFiles, flagStdin, flagOutput = previousFiles, previousStdin, previousOutput }()
+
+	config, err := loadConfig(rootCmd, []string{"why", "does", "this", "panic"})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if !strings.Contains(config.Query, "secretHelper") {
+		t.Errorf("Query = %q; want the attached file", config.Query)
+	}
+	if config.Question != "why does this panic" {
+		t.Errorf("Question = %q; want the question without attachments", config.Question)
+	}
+	if config.OutputPath != "why-does-this-panic.txt" {
+		t.Errorf("OutputPath = %q; want a name from the question", config.OutputPath)
+	}
+
+	config.Format = formatMarkdown
+	if output := formatMarkdown_output(&FastGPTResponse{}, config); strings.Contains(output, "secretHelper") {
+		t.Errorf("Attached content leaked into the output:\n%s", output)
+	}
+}
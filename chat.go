@@ -0,0 +1,675 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Message length limits, in characters
+	slackMessageLimit    = 4000 // Slack's recommended maximum; longer messages are truncated
+	slackSectionLimit    = 3000 // Block Kit section block text
+	slackHeaderLimit     = 150  // Block Kit header block text
+	slackBlocksLimit     = 50   // Blocks per Block Kit message
+	discordMessageLimit  = 2000
+	telegramMessageLimit = 4096
+
+	// chatMessageSeparator separates messages when a long answer is split: a
+	// --- line between blank lines. With --print0 a NUL byte is used instead,
+	// so relays can send each one with e.g. `xargs -0`.
+	chatMessageSeparator       = "\n\n---\n\n"
+	chatMessageSeparatorPrint0 = "\x00"
+
+	// fenceReserve leaves room to close and reopen a code fence when a code
+	// block is split across messages
+	fenceReserve = 32
+)
+
+// formatSlack_output renders the response as Slack mrkdwn messages.
+func formatSlack_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if !config.Quiet {
+		output.WriteString("*")
+		output.WriteString(slackEscape(oneLine(config.Question)))
+		output.WriteString("*\n\n")
+	}
+
+	output.WriteString(markdownToSlack(resp.Data.Output))
+
+	if !config.Quiet && len(resp.Data.References) > 0 {
+		output.WriteString("\n\n*References*\n")
+		output.WriteString(slackReferences(resp.Data.References))
+	}
+
+	return joinMessages(splitMessage(output.String(), slackMessageLimit), config)
+}
+
+// formatSlackBlocks_output renders the response as Slack Block Kit message
+// payloads, one JSON object per line.
+func formatSlackBlocks_output(resp *FastGPTResponse, config *Config) (string, error) {
+	var blocks []map[string]any
+
+	section := func(text string) {
+		for _, chunk := range splitMessage(text, slackSectionLimit) {
+			blocks = append(blocks, map[string]any{
+				"type": "section",
+				"text": map[string]any{"type": "mrkdwn", "text": chunk},
+			})
+		}
+	}
+
+	fallback := oneLine(config.Question)
+	if !config.Quiet {
+		blocks = append(blocks, map[string]any{
+			"type": "header",
+			"text": map[string]any{"type": "plain_text", "text": truncateRunes(fallback, slackHeaderLimit), "emoji": true},
+		})
+	} else {
+		fallback = oneLine(resp.Data.Output)
+	}
+
+	section(markdownToSlack(resp.Data.Output))
+
+	if !config.Quiet && len(resp.Data.References) > 0 {
+		blocks = append(blocks, map[string]any{"type": "divider"})
+		section("*References*\n" + slackReferences(resp.Data.References))
+	}
+
+	var output strings.Builder
+	for start := 0; start < len(blocks); start += slackBlocksLimit {
+		end := min(start+slackBlocksLimit, len(blocks))
+		payload := map[string]any{
+			// Shown in notifications and clients that cannot render blocks
+			"text":   truncateRunes(fallback, slackHeaderLimit),
+			"blocks": blocks[start:end],
+		}
+		// Slack's own escapes (&lt; etc.) are already applied, so keep < > & literal
+		encoder := json.NewEncoder(&output)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(payload); err != nil {
+			return "", fmt.Errorf("failed to marshal Block Kit payload: %w", err)
+		}
+	}
+
+	return output.String(), nil
+}
+
+// formatDiscord_output renders the response as Discord markdown messages.
+func formatDiscord_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if !config.Quiet {
+		output.WriteString("## ")
+		output.WriteString(discordEscape(oneLine(config.Question)))
+		output.WriteString("\n\n")
+	}
+
+	output.WriteString(markdownToDiscord(resp.Data.Output))
+
+	if !config.Quiet && len(resp.Data.References) > 0 {
+		output.WriteString("\n\n**References**\n")
+		for i, ref := range resp.Data.References {
+			output.WriteString(fmt.Sprintf("%d. %s\n", i+1, discordLink(ref.Title, ref.URL)))
+			if ref.Snippet != "" {
+				output.WriteString("> ")
+				output.WriteString(discordEscape(oneLine(ref.Snippet)))
+				output.WriteString("\n")
+			}
+		}
+	}
+
+	return joinMessages(splitMessage(output.String(), discordMessageLimit), config)
+}
+
+// formatTelegram_output renders the response as Telegram MarkdownV2 messages.
+func formatTelegram_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if !config.Quiet {
+		output.WriteString("*")
+		output.WriteString(telegramEscape(oneLine(config.Question)))
+		output.WriteString("*\n\n")
+	}
+
+	output.WriteString(markdownToTelegram(resp.Data.Output))
+
+	if !config.Quiet && len(resp.Data.References) > 0 {
+		output.WriteString("\n\n*References*\n")
+		for i, ref := range resp.Data.References {
+			output.WriteString(fmt.Sprintf("%d\\. %s\n", i+1, telegramLink(ref.Title, ref.URL)))
+			if ref.Snippet != "" {
+				output.WriteString(">")
+				output.WriteString(telegramEscape(oneLine(ref.Snippet)))
+				output.WriteString("\n")
+			}
+		}
+	}
+
+	return joinMessages(splitMessage(output.String(), telegramMessageLimit), config)
+}
+
+func joinMessages(messages []string, config *Config) string {
+	if config.Print0 {
+		return strings.Join(messages, chatMessageSeparatorPrint0) + "\n"
+	}
+	return strings.Join(messages, chatMessageSeparator) + "\n"
+}
+
+// Slack mrkdwn
+
+func markdownToSlack(src string) string {
+	var out []string
+
+	for _, block := range parseMarkdown(src) {
+		switch block.Kind {
+		case mdHeading:
+			out = append(out, "*"+headingToSlack(block.Text)+"*")
+		case mdCodeBlock:
+			// Slack does not support a language on code blocks
+			out = append(out, "```\n"+slackEscape(block.Text)+"\n```")
+		case mdList:
+			out = append(out, chatList(block, inlineToSlack, "•", "%d."))
+		case mdQuote:
+			out = append(out, "> "+inlineToSlack(block.Text))
+		case mdRule:
+			out = append(out, "───")
+		default:
+			out = append(out, inlineToSlack(block.Text))
+		}
+	}
+
+	return strings.Join(out, "\n\n")
+}
+
+func inlineToSlack(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		out.WriteString(spanToSlack(span))
+	}
+
+	return out.String()
+}
+
+// headingToSlack converts heading text for a bold line. Bold spans are
+// written plain, since Slack cannot nest bold.
+func headingToSlack(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		if span.Kind == mdBold {
+			out.WriteString(slackEscape(span.Text))
+		} else {
+			out.WriteString(spanToSlack(span))
+		}
+	}
+
+	return out.String()
+}
+
+func spanToSlack(span mdInline) string {
+	switch span.Kind {
+	case mdBold:
+		return "*" + slackEscape(span.Text) + "*"
+	case mdItalic:
+		return "_" + slackEscape(span.Text) + "_"
+	case mdCode:
+		return "`" + slackEscape(span.Text) + "`"
+	case mdLink:
+		return slackLink(span.Text, span.URL)
+	default:
+		return slackEscape(span.Text)
+	}
+}
+
+func slackReferences(refs []Reference) string {
+	var out strings.Builder
+	for i, ref := range refs {
+		out.WriteString(fmt.Sprintf("%d. %s\n", i+1, slackLink(ref.Title, ref.URL)))
+		if ref.Snippet != "" {
+			out.WriteString("> ")
+			out.WriteString(slackEscape(oneLine(ref.Snippet)))
+			out.WriteString("\n")
+		}
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
+
+func slackLink(text, url string) string {
+	text = slackEscape(oneLine(text))
+	url = strings.NewReplacer("|", "%7C", ">", "%3E", "<", "%3C", " ", "%20").Replace(strings.TrimSpace(url))
+	if url == "" {
+		return text
+	}
+	if text == "" {
+		return "<" + url + ">"
+	}
+	return "<" + url + "|" + text + ">"
+}
+
+// slackEscape escapes the three characters Slack reserves for control sequences.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Discord markdown
+
+func markdownToDiscord(src string) string {
+	var out []string
+
+	for _, block := range parseMarkdown(src) {
+		switch block.Kind {
+		case mdHeading:
+			// Discord only renders three heading levels
+			out = append(out, strings.Repeat("#", min(block.Level, 3))+" "+inlineToDiscord(block.Text))
+		case mdCodeBlock:
+			out = append(out, "```"+block.Lang+"\n"+block.Text+"\n```")
+		case mdList:
+			out = append(out, chatList(block, inlineToDiscord, "-", "%d."))
+		case mdQuote:
+			out = append(out, "> "+inlineToDiscord(block.Text))
+		case mdRule:
+			out = append(out, "───")
+		default:
+			out = append(out, inlineToDiscord(block.Text))
+		}
+	}
+
+	return strings.Join(out, "\n\n")
+}
+
+func inlineToDiscord(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		switch span.Kind {
+		case mdBold:
+			out.WriteString("**" + discordEscape(span.Text) + "**")
+		case mdItalic:
+			out.WriteString("*" + discordEscape(span.Text) + "*")
+		case mdCode:
+			out.WriteString("`" + span.Text + "`")
+		case mdLink:
+			out.WriteString(discordLink(span.Text, span.URL))
+		default:
+			out.WriteString(discordEscape(span.Text))
+		}
+	}
+
+	return out.String()
+}
+
+func discordLink(text, url string) string {
+	text = discordEscape(oneLine(text))
+	url = strings.NewReplacer(")", "%29", ">", "%3E", " ", "%20").Replace(strings.TrimSpace(url))
+	if url == "" {
+		return text
+	}
+	if text == "" {
+		return "<" + url + ">"
+	}
+	// Angle brackets stop Discord from unfurling an embed for every reference
+	return "[" + text + "](<" + url + ">)"
+}
+
+// discordEscape backslash-escapes Discord markdown characters.
+func discordEscape(text string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		"*", "\\*",
+		"_", "\\_",
+		"~", "\\~",
+		"`", "\\`",
+		"|", "\\|",
+		"[", "\\[",
+		"]", "\\]",
+	).Replace(text)
+}
+
+// Telegram MarkdownV2
+
+func markdownToTelegram(src string) string {
+	var out []string
+
+	for _, block := range parseMarkdown(src) {
+		switch block.Kind {
+		case mdHeading:
+			// Telegram has no headings, so they are rendered bold
+			out = append(out, "*"+headingToTelegram(block.Text)+"*")
+		case mdCodeBlock:
+			out = append(out, "```"+block.Lang+"\n"+telegramCodeEscape(block.Text)+"\n```")
+		case mdList:
+			out = append(out, chatList(block, inlineToTelegram, "•", "%d\\."))
+		case mdQuote:
+			out = append(out, ">"+inlineToTelegram(block.Text))
+		case mdRule:
+			out = append(out, "───")
+		default:
+			out = append(out, inlineToTelegram(block.Text))
+		}
+	}
+
+	return strings.Join(out, "\n\n")
+}
+
+func inlineToTelegram(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		out.WriteString(spanToTelegram(span))
+	}
+
+	return out.String()
+}
+
+// headingToTelegram converts heading text for a bold line. Bold spans are
+// written plain, since Telegram cannot nest bold.
+func headingToTelegram(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		if span.Kind == mdBold {
+			out.WriteString(telegramEscape(span.Text))
+		} else {
+			out.WriteString(spanToTelegram(span))
+		}
+	}
+
+	return out.String()
+}
+
+func spanToTelegram(span mdInline) string {
+	switch span.Kind {
+	case mdBold:
+		return "*" + telegramEscape(span.Text) + "*"
+	case mdItalic:
+		return "_" + telegramEscape(span.Text) + "_"
+	case mdCode:
+		return "`" + telegramCodeEscape(span.Text) + "`"
+	case mdLink:
+		return telegramLink(span.Text, span.URL)
+	default:
+		return telegramEscape(span.Text)
+	}
+}
+
+func telegramLink(text, url string) string {
+	text = telegramEscape(oneLine(text))
+	url = strings.TrimSpace(url)
+	if url == "" {
+		return text
+	}
+	if text == "" {
+		text = telegramEscape(url)
+	}
+	return "[" + text + "](" + strings.NewReplacer("\\", "\\\\", ")", "\\)").Replace(url) + ")"
+}
+
+// telegramEscape escapes every character MarkdownV2 reserves outside entities.
+func telegramEscape(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("\\_*[]()~`>#+-=|{}.!", r) {
+			out.WriteByte('\\')
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// telegramCodeEscape escapes the characters MarkdownV2 reserves inside code.
+func telegramCodeEscape(text string) string {
+	return strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+}
+
+// chatList renders a list block with the given bullet, or numbered using
+// numberFormat, converting each item with inline.
+func chatList(block mdBlock, inline func(string) string, bullet, numberFormat string) string {
+	items := make([]string, len(block.Items))
+	for i, item := range block.Items {
+		marker := bullet
+		if block.Ordered {
+			marker = fmt.Sprintf(numberFormat, i+1)
+		}
+		items[i] = marker + " " + inline(item)
+	}
+	return strings.Join(items, "\n")
+}
+
+// Message splitting
+
+// splitMessage splits text into messages of at most limit characters. It
+// breaks between paragraphs where possible, then between lines, then between
+// words, and closes and reopens code fences that span a break.
+func splitMessage(text string, limit int) []string {
+	var messages []string
+	var current strings.Builder
+
+	for _, unit := range messageUnits(strings.TrimRight(text, "\n")) {
+		for _, piece := range splitUnit(unit, limit) {
+			if current.Len() > 0 && utf8.RuneCountInString(current.String())+2+utf8.RuneCountInString(piece) > limit {
+				messages = append(messages, current.String())
+				current.Reset()
+			}
+			if current.Len() > 0 {
+				current.WriteString("\n\n")
+			}
+			current.WriteString(piece)
+		}
+	}
+	if current.Len() > 0 || len(messages) == 0 {
+		messages = append(messages, current.String())
+	}
+
+	return messages
+}
+
+// messageUnits splits text into paragraphs, keeping code blocks whole.
+func messageUnits(text string) []string {
+	var units []string
+	var current []string
+	inFence := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" && !inFence {
+			if len(current) > 0 {
+				units = append(units, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		units = append(units, strings.Join(current, "\n"))
+	}
+
+	return units
+}
+
+// splitUnit splits a single paragraph or code block that is longer than limit.
+func splitUnit(unit string, limit int) []string {
+	if utf8.RuneCountInString(unit) <= limit {
+		return []string{unit}
+	}
+
+	var pieces []string
+	var current []string
+	currentLen := 0
+	openFence := "" // the fence line of the code block we are inside, if any
+
+	for _, line := range strings.Split(unit, "\n") {
+		for _, part := range splitLine(line, limit-fenceReserve) {
+			partLen := utf8.RuneCountInString(part)
+			if len(current) > 0 && currentLen+1+partLen+fenceReserve > limit {
+				piece := strings.Join(current, "\n")
+				if openFence != "" {
+					piece += "\n```"
+				}
+				pieces = append(pieces, piece)
+				current, currentLen = nil, 0
+				if openFence != "" {
+					current, currentLen = []string{openFence}, utf8.RuneCountInString(openFence)
+				}
+			}
+			if len(current) > 0 {
+				currentLen++
+			}
+			current = append(current, part)
+			currentLen += partLen
+		}
+
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") {
+			if openFence == "" {
+				openFence = trimmed
+			} else {
+				openFence = ""
+			}
+		}
+	}
+	if len(current) > 0 {
+		pieces = append(pieces, strings.Join(current, "\n"))
+	}
+
+	return pieces
+}
+
+// splitLine breaks a line longer than limit at word boundaries outside
+// inline markup, so that entities such as *bold* or links are not cut in two
+// messages, which Telegram rejects. It falls back to any word boundary, then
+// to a character boundary when a single word is too long, but never splits
+// an escape sequence.
+func splitLine(line string, limit int) []string {
+	limit = max(limit, 1)
+	var parts []string
+
+	for utf8.RuneCountInString(line) > limit {
+		runes := []rune(line)
+		inside := markupCuts(runes)
+
+		cut := lastCut(limit, func(i int) bool { return runes[i] == ' ' && !inside[i] })
+		if cut == 0 {
+			cut = lastCut(limit, func(i int) bool { return runes[i] == ' ' && !escapedAt(runes, i) })
+		}
+		if cut == 0 {
+			cut = lastCut(limit, func(i int) bool { return !escapedAt(runes, i) })
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		parts = append(parts, strings.TrimRight(string(runes[:cut]), " "))
+		line = strings.TrimLeft(string(runes[cut:]), " ")
+	}
+
+	return append(parts, line)
+}
+
+// lastCut returns the last position from limit down to 1 where ok allows a
+// cut before the rune at that position, or 0.
+func lastCut(limit int, ok func(int) bool) int {
+	for i := limit; i > 0; i-- {
+		if ok(i) {
+			return i
+		}
+	}
+	return 0
+}
+
+// markupCuts reports, for each position in runes, whether a cut before it
+// would fall inside inline markup: an escape sequence, a *bold*, _italic_,
+// ~strike~ or `code` span, a [text](url) link or a Slack <url|text> link.
+// Delimiters without a closing match are literal text.
+func markupCuts(runes []rune) []bool {
+	inside := make([]bool, len(runes)+1)
+	mark := func(open, close int) {
+		for i := open + 1; i <= close; i++ {
+			inside[i] = true
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\\':
+			if i+1 < len(runes) {
+				mark(i, i+1)
+			}
+			i++
+		case '*', '_', '~', '`':
+			run := 1
+			for i+run < len(runes) && runes[i+run] == r {
+				run++
+			}
+			if end := findCloser(runes, i+run, string(runes[i:i+run])); end >= 0 {
+				mark(i, end)
+				i = end
+			} else {
+				i += run - 1
+			}
+		case '[':
+			textEnd := findCloser(runes, i+1, "](")
+			if textEnd < 0 {
+				continue
+			}
+			if end := findCloser(runes, textEnd+1, ")"); end >= 0 {
+				mark(i, end)
+				i = end
+			}
+		case '<':
+			if i+1 < len(runes) && runes[i+1] != ' ' {
+				if end := findCloser(runes, i+1, ">"); end >= 0 {
+					mark(i, end)
+					i = end
+				}
+			}
+		}
+	}
+
+	return inside
+}
+
+// findCloser returns the index of the last rune of the first unescaped
+// occurrence of delim in runes at or after from, or -1. Code spans are
+// skipped unless delim closes one.
+func findCloser(runes []rune, from int, delim string) int {
+	want := []rune(delim)
+	for i := from; i < len(runes); i++ {
+		if runes[i] == '\\' {
+			i++
+			continue
+		}
+		if runes[i] == '`' && want[0] != '`' {
+			if end := findCloser(runes, i+1, "`"); end >= 0 {
+				i = end
+				continue
+			}
+		}
+		if i+len(want) <= len(runes) && string(runes[i:i+len(want)]) == delim {
+			return i + len(want) - 1
+		}
+	}
+	return -1
+}
+
+// escapedAt reports whether runes[i] is escaped by a backslash.
+func escapedAt(runes []rune, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && runes[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// truncateRunes shortens text to at most n characters, marking the cut with an ellipsis.
+func truncateRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
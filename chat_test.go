@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatSlack_output(t *testing.T) {
+	resp := createTestResponse()
+	resp.Data.Output = "**Go** is *fast* & <simple>"
+
+	config := &Config{Question: "test query", Format: formatSlack}
+	result := formatSlack_output(resp, config)
+
+	if !strings.HasPrefix(result, "*test query*\n\n") {
+		t.Errorf("Slack output missing bold heading")
+	}
+	if !strings.Contains(result, "*Go* is _fast_ &amp; &lt;simple&gt;") {
+		t.Errorf("Slack output did not convert emphasis and escape control characters: %q", result)
+	}
+	if !strings.Contains(result, "1. <https://example.com/1|Test Reference 1>") {
+		t.Errorf("Slack output missing mrkdwn link")
+	}
+	if !strings.Contains(result, "> First test snippet") {
+		t.Errorf("Slack output missing quoted snippet")
+	}
+	if strings.Contains(result, chatMessageSeparator) {
+		t.Errorf("Short answer should not be split")
+	}
+}
+
+func TestFormatSlackBlocks_output(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("single message payload", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatSlackBlocks}
+		result, err := formatSlackBlocks_output(resp, config)
+		if err != nil {
+			t.Fatalf("formatSlackBlocks_output failed: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(result), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("Expected 1 payload, got %d", len(lines))
+		}
+
+		var payload struct {
+			Text   string `json:"text"`
+			Blocks []struct {
+				Type string `json:"type"`
+				Text struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"text"`
+			} `json:"blocks"`
+		}
+		if err := json.Unmarshal([]byte(lines[0]), &payload); err != nil {
+			t.Fatalf("Payload is not valid JSON: %v", err)
+		}
+
+		if payload.Text != "test query" {
+			t.Errorf("Fallback text = %q; want %q", payload.Text, "test query")
+		}
+		if payload.Blocks[0].Type != "header" || payload.Blocks[0].Text.Text != "test query" {
+			t.Errorf("First block should be the query header: %+v", payload.Blocks[0])
+		}
+		if payload.Blocks[1].Type != "section" || payload.Blocks[1].Text.Type != "mrkdwn" {
+			t.Errorf("Second block should be a mrkdwn section: %+v", payload.Blocks[1])
+		}
+		if !strings.Contains(lines[0], "<https://example.com/1|Test Reference 1>") {
+			t.Errorf("Payload missing reference link")
+		}
+	})
+
+	t.Run("long answer split into sections and messages", func(t *testing.T) {
+		long := createTestResponse()
+		long.Data.Output = strings.Repeat(strings.Repeat("word ", 100)+"\n\n", 400)
+
+		config := &Config{Question: "test query", Format: formatSlackBlocks, Quiet: true}
+		result, err := formatSlackBlocks_output(long, config)
+		if err != nil {
+			t.Fatalf("formatSlackBlocks_output failed: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(result), "\n")
+		if len(lines) < 2 {
+			t.Fatalf("Expected multiple payloads, got %d", len(lines))
+		}
+		for _, line := range lines {
+			var payload struct {
+				Blocks []struct {
+					Text struct {
+						Text string `json:"text"`
+					} `json:"text"`
+				} `json:"blocks"`
+			}
+			if err := json.Unmarshal([]byte(line), &payload); err != nil {
+				t.Fatalf("Payload is not valid JSON: %v", err)
+			}
+			if len(payload.Blocks) > slackBlocksLimit {
+				t.Errorf("Payload has %d blocks; limit is %d", len(payload.Blocks), slackBlocksLimit)
+			}
+			for _, block := range payload.Blocks {
+				if n := utf8.RuneCountInString(block.Text.Text); n > slackSectionLimit {
+					t.Errorf("Section text is %d characters; limit is %d", n, slackSectionLimit)
+				}
+			}
+		}
+	})
+}
+
+func TestFormatDiscord_output(t *testing.T) {
+	resp := createTestResponse()
+	resp.Data.References[0].Title = "Title [with] *stars*"
+
+	config := &Config{Question: "test query", Format: formatDiscord}
+	result := formatDiscord_output(resp, config)
+
+	if !strings.HasPrefix(result, "## test query\n\n") {
+		t.Errorf("Discord output missing heading")
+	}
+	if !strings.Contains(result, `1. [Title \[with\] \*stars\*](<https://example.com/1>)`) {
+		t.Errorf("Discord output missing escaped masked link: %q", result)
+	}
+	if !strings.Contains(result, "**References**") {
+		t.Errorf("Discord output missing references section")
+	}
+}
+
+func TestFormatTelegram_output(t *testing.T) {
+	resp := createTestResponse()
+	resp.Data.Output = "Version 1.22 is **stable**! See `go.mod`."
+
+	config := &Config{Question: "what's new?", Format: formatTelegram}
+	result := formatTelegram_output(resp, config)
+
+	if !strings.HasPrefix(result, "*what's new?*\n\n") {
+		t.Errorf("Telegram output missing bold heading: %q", result)
+	}
+	if !strings.Contains(result, "Version 1\\.22 is *stable*\\! See `go.mod`\\.") {
+		t.Errorf("Telegram output not escaped for MarkdownV2: %q", result)
+	}
+	if !strings.Contains(result, "1\\. [Test Reference 1](https://example.com/1)") {
+		t.Errorf("Telegram output missing reference link: %q", result)
+	}
+}
+
+func TestChatHeadingsInlineMarkup(t *testing.T) {
+	resp := createTestResponse()
+	resp.Data.Output = "## **Bold** and [link](https://example.com/x)\n\nBody."
+
+	config := &Config{Question: "q", Format: formatSlack}
+	slack := formatSlack_output(resp, config)
+	if !strings.Contains(slack, "*Bold and <https://example.com/x|link>*") {
+		t.Errorf("Slack heading inline markup not converted: %q", slack)
+	}
+
+	config.Format = formatTelegram
+	telegram := formatTelegram_output(resp, config)
+	if !strings.Contains(telegram, "*Bold and [link](https://example.com/x)*") {
+		t.Errorf("Telegram heading inline markup not converted: %q", telegram)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	t.Run("short text is one message", func(t *testing.T) {
+		messages := splitMessage("hello\n\nworld\n", 100)
+		if len(messages) != 1 || messages[0] != "hello\n\nworld" {
+			t.Errorf("splitMessage() = %q", messages)
+		}
+	})
+
+	t.Run("splits between paragraphs", func(t *testing.T) {
+		para := strings.Repeat("a", 60)
+		messages := splitMessage(para+"\n\n"+para+"\n\n"+para, 130)
+		if len(messages) != 2 {
+			t.Fatalf("splitMessage() returned %d messages; want 2", len(messages))
+		}
+		if messages[0] != para+"\n\n"+para || messages[1] != para {
+			t.Errorf("splitMessage() = %q", messages)
+		}
+	})
+
+	t.Run("long paragraph split at words", func(t *testing.T) {
+		text := strings.Repeat("word ", 500)
+		for _, message := range splitMessage(text, 200) {
+			if utf8.RuneCountInString(message) > 200 {
+				t.Errorf("Message of %d characters exceeds limit", utf8.RuneCountInString(message))
+			}
+			if strings.Contains(message, "wor\n") || strings.HasPrefix(message, "rd") {
+				t.Errorf("Message split inside a word: %q", message)
+			}
+		}
+	})
+
+	t.Run("code block closed and reopened", func(t *testing.T) {
+		code := "```go\n" + strings.Repeat("x := 1\n", 60) + "```"
+		messages := splitMessage(code, 200)
+		if len(messages) < 2 {
+			t.Fatalf("Expected code block to be split")
+		}
+		for i, message := range messages {
+			if utf8.RuneCountInString(message) > 200 {
+				t.Errorf("Message %d exceeds limit", i)
+			}
+			if strings.Count(message, "```")%2 != 0 {
+				t.Errorf("Message %d has unbalanced code fences: %q", i, message)
+			}
+			if !strings.HasPrefix(message, "```go\n") {
+				t.Errorf("Message %d should open the code block: %q", i, message)
+			}
+		}
+	})
+
+	t.Run("escape sequences kept together", func(t *testing.T) {
+		parts := splitLine(strings.Repeat("\\.", 50), 9)
+		for _, part := range parts {
+			if strings.HasSuffix(part, "\\") && !strings.HasSuffix(part, "\\.") {
+				t.Errorf("Part ends with a dangling escape: %q", part)
+			}
+		}
+	})
+}
+
+func TestSplitLineMarkup(t *testing.T) {
+	// unescapedCount counts the unescaped occurrences of r
+	unescapedCount := func(text string, r rune) int {
+		runes := []rune(text)
+		count := 0
+		for i := range runes {
+			if runes[i] == r && !escapedAt(runes, i) {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("telegram bold kept in one message", func(t *testing.T) {
+		line := "Go is " + telegramEscape("simple") + " and *" + telegramEscape("very fast at compiling large programs") + "* " + strings.Repeat("word ", 10)
+		parts := splitLine(line, 45)
+		for _, part := range parts {
+			if unescapedCount(part, '*')%2 != 0 {
+				t.Errorf("Part splits a bold entity: %q", part)
+			}
+		}
+		if strings.TrimSpace(strings.Join(parts, " ")) != strings.TrimSpace(line) {
+			t.Errorf("Parts lost text: %q", parts)
+		}
+	})
+
+	t.Run("links kept in one message", func(t *testing.T) {
+		line := strings.Repeat("a ", 10) + telegramLink("the Go programming language specification", "https://go.dev/ref/spec") + " " + strings.Repeat("b ", 10)
+		for _, part := range splitLine(line, 80) {
+			if strings.Count(part, "[") != strings.Count(part, "](") || strings.Count(part, "(") != strings.Count(part, ")") {
+				t.Errorf("Part splits a link: %q", part)
+			}
+		}
+	})
+
+	t.Run("slack links kept in one message", func(t *testing.T) {
+		line := strings.Repeat("a ", 10) + slackLink("the Go programming language specification", "https://go.dev/ref/spec") + " " + strings.Repeat("b ", 10)
+		for _, part := range splitLine(line, 70) {
+			if strings.Count(part, "<") != strings.Count(part, ">") {
+				t.Errorf("Part splits a link: %q", part)
+			}
+		}
+	})
+
+	t.Run("escaped backslashes", func(t *testing.T) {
+		for _, part := range splitLine(strings.Repeat(`\\.`, 30), 10) {
+			if runes := []rune(part); len(runes) > 0 && runes[len(runes)-1] == '\\' && !escapedAt(runes, len(runes)-1) {
+				t.Errorf("Part ends with a dangling escape: %q", part)
+			}
+		}
+	})
+
+	t.Run("unmatched delimiters are literal", func(t *testing.T) {
+		line := "snake_case " + strings.Repeat("word ", 20)
+		for _, part := range splitLine(line, 20) {
+			if utf8.RuneCountInString(part) > 20 {
+				t.Errorf("Part of %d characters exceeds the limit: %q", utf8.RuneCountInString(part), part)
+			}
+			if strings.HasPrefix(part, "ord") {
+				t.Errorf("Part split inside a word: %q", part)
+			}
+		}
+	})
+}
+
+func TestJoinMessages(t *testing.T) {
+	messages := []string{"first", "second"}
+	if got := joinMessages(messages, &Config{}); got != "first\n\n---\n\nsecond\n" {
+		t.Errorf("joinMessages() = %q; want a visible --- separator", got)
+	}
+	if got := joinMessages(messages, &Config{Print0: true}); got != "first\x00second\n" {
+		t.Errorf("joinMessages() with --print0 = %q; want a NUL separator", got)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if result := truncateRunes("short", 10); result != "short" {
+		t.Errorf("truncateRunes() = %q; want unchanged", result)
+	}
+	if result := truncateRunes("你好世界你好", 4); result != "你好世…" {
+		t.Errorf("truncateRunes() = %q; want %q", result, "你好世…")
+	}
+}
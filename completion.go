@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate a shell completion script",
+	Long: `Generate a shell completion script for kagi.
+
+  # Bash (current shell, or add to ~/.bashrc)
+  source <(kagi completion bash)
+
+  # Zsh (add to ~/.zshrc)
+  source <(kagi completion zsh)
+
+  # Fish
+  kagi completion fish > ~/.config/fish/completions/kagi.fish
+
+  # PowerShell (add to $PROFILE)
+  kagi completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(out, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(out)
+		case "fish":
+			return rootCmd.GenFishCompletion(out, true)
+		default:
+			return rootCmd.GenPowerShellCompletionWithDesc(out)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+// formatCompletions lists the --format values with descriptions.
+var formatCompletions = []cobra.Completion{
+	cobra.CompletionWithDesc(formatText, "Plain text (default)"),
+	cobra.CompletionWithDesc(formatMarkdown, "Markdown"),
+	cobra.CompletionWithDesc(formatJSON, "JSON envelope"),
+	cobra.CompletionWithDesc(formatJSONL, "JSON envelope, one line"),
+	cobra.CompletionWithDesc(formatHTML, "HTML fragment or page"),
+	cobra.CompletionWithDesc(formatOrg, "Org-mode"),
+	cobra.CompletionWithDesc(formatAsciiDoc, "AsciiDoc"),
+	cobra.CompletionWithDesc(formatRST, "reStructuredText"),
+	cobra.CompletionWithDesc(formatSlack, "Slack mrkdwn"),
+	cobra.CompletionWithDesc(formatSlackBlocks, "Slack Block Kit JSON"),
+	cobra.CompletionWithDesc(formatDiscord, "Discord markdown"),
+	cobra.CompletionWithDesc(formatTelegram, "Telegram MarkdownV2"),
+}
+
+// fieldCompletions are commonly selected --field paths.
+var fieldCompletions = []cobra.Completion{
+	cobra.CompletionWithDesc("data.output", "The answer"),
+	cobra.CompletionWithDesc("data.tokens", "Tokens used"),
+	cobra.CompletionWithDesc("data.references.*.url", "Every reference URL"),
+	cobra.CompletionWithDesc("data.references.*.title", "Every reference title"),
+	cobra.CompletionWithDesc("data.references.0.url", "First reference URL"),
+	cobra.CompletionWithDesc("meta.ms", "Processing time in milliseconds"),
+	cobra.CompletionWithDesc("meta.id", "Request ID"),
+}
+
+// registerCompletions adds value completions for cmd's flags. It runs from
+// main.go's init, after the flags are defined. A flag that cannot be
+// registered is reported, but the others are still registered.
+func registerCompletions(cmd *cobra.Command) error {
+	modes := []cobra.Completion{
+		cobra.CompletionWithDesc(colorAuto, "When stdout is a terminal"),
+		cobra.CompletionWithDesc(colorAlways, "Always"),
+		cobra.CompletionWithDesc(colorNever, "Never"),
+	}
+
+	completions := map[string]cobra.CompletionFunc{
+		"format":     cobra.FixedCompletions(formatCompletions, cobra.ShellCompDirectiveNoFileComp),
+		"color":      cobra.FixedCompletions(modes, cobra.ShellCompDirectiveNoFileComp),
+		"hyperlinks": cobra.FixedCompletions(modes, cobra.ShellCompDirectiveNoFileComp),
+		"raw": cobra.FixedCompletions([]cobra.Completion{
+			cobra.CompletionWithDesc(rawExact, "Body as received"),
+			cobra.CompletionWithDesc(rawPretty, "Body re-indented"),
+		}, cobra.ShellCompDirectiveNoFileComp),
+		"field":    cobra.FixedCompletions(fieldCompletions, cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveNoSpace),
+		"template": completeTemplateNames,
+		"prompt":   completePromptNames,
+		"timeout":  cobra.NoFileCompletions,
+		"endpoint": cobra.NoFileCompletions,
+		"stdin": cobra.FixedCompletions([]cobra.Completion{
+			cobra.CompletionWithDesc(stdinAuto, "Stdin is context when a question is given"),
+			cobra.CompletionWithDesc(stdinContext, "Always use stdin as context"),
+			cobra.CompletionWithDesc(stdinQuery, "Stdin is the whole query"),
+			cobra.CompletionWithDesc(stdinIgnore, "Never read stdin"),
+		}, cobra.ShellCompDirectiveNoFileComp),
+		"stdin-template": cobra.NoFileCompletions,
+		"file-budget":    cobra.NoFileCompletions,
+		"dry-run": cobra.FixedCompletions([]cobra.Completion{
+			cobra.CompletionWithDesc(dryRunJSON, "Request body"),
+			cobra.CompletionWithDesc(dryRunHTTP, "Raw HTTP request"),
+			cobra.CompletionWithDesc(dryRunCurl, "Equivalent curl command"),
+		}, cobra.ShellCompDirectiveNoFileComp),
+		"record": cobra.FixedCompletions(nil, cobra.ShellCompDirectiveFilterDirs),
+		"replay": cobra.FixedCompletions(nil, cobra.ShellCompDirectiveFilterDirs),
+	}
+
+	var errs []error
+	for name, completion := range completions {
+		if err := cmd.RegisterFlagCompletionFunc(name, completion); err != nil {
+			errs = append(errs, fmt.Errorf("failed to register completion for --%s: %w", name, err))
+		}
+	}
+
+	// Queries are free text, never file names; with --prompt the arguments
+	// are the prompt's variables
+	cmd.ValidArgsFunction = completePromptArgs
+
+	return errors.Join(errs...)
+}
+
+// completeTemplateNames suggests the named templates in the config directory.
+func completeTemplateNames(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	paths, _ := filepath.Glob(filepath.Join(configDir(), templatesDirName, "*"+templateExt))
+
+	var names []cobra.Completion
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), templateExt)
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
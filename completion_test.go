@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	tests := []struct {
+		shell    string
+		contains string
+	}{
+		{"bash", "# bash completion V2 for kagi"},
+		{"zsh", "#compdef kagi"},
+		{"fish", "complete -c kagi"},
+		{"powershell", "Register-ArgumentCompleter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			var out bytes.Buffer
+			completionCmd.SetOut(&out)
+			t.Cleanup(func() { completionCmd.SetOut(nil) })
+
+			if err := completionCmd.RunE(completionCmd, []string{tt.shell}); err != nil {
+				t.Fatalf("completion %s failed: %v", tt.shell, err)
+			}
+			if !strings.Contains(out.String(), tt.contains) {
+				t.Errorf("completion %s output missing %q", tt.shell, tt.contains)
+			}
+		})
+	}
+}
+
+func TestFormatCompletions(t *testing.T) {
+	// Every suggested format must be accepted by --format
+	for _, completion := range formatCompletions {
+		format := strings.SplitN(completion, "\t", 2)[0]
+		if !isValidFormat(format) {
+			t.Errorf("Completion %q is not a valid format", format)
+		}
+	}
+}
+
+func TestFlagCompletionsRegistered(t *testing.T) {
+	for _, name := range []string{"format", "color", "hyperlinks", "raw", "field", "template"} {
+		t.Run(name, func(t *testing.T) {
+			completion, ok := rootCmd.GetFlagCompletionFunc(name)
+			if !ok {
+				t.Fatalf("No completion registered for --%s", name)
+			}
+			values, directive := completion(rootCmd, nil, "")
+			if directive&cobra.ShellCompDirectiveNoFileComp == 0 {
+				t.Errorf("--%s completion should not fall back to file names", name)
+			}
+			if name != "template" && len(values) == 0 {
+				t.Errorf("--%s completion returned no values", name)
+			}
+		})
+	}
+}
+
+func TestRegisterCompletionsMissingFlags(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("format", "", "")
+
+	err := registerCompletions(cmd)
+	if err == nil {
+		t.Fatal("registerCompletions() = nil; want an error for the missing flags")
+	}
+	if !strings.Contains(err.Error(), "--color") {
+		t.Errorf("Error should name the missing flag --color: %v", err)
+	}
+	if _, ok := cmd.GetFlagCompletionFunc("format"); !ok {
+		t.Error("Flags that exist should still be registered")
+	}
+}
+
+func TestCompleteTemplateNames(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envConfigDir, dir)
+	templates := filepath.Join(dir, templatesDirName)
+	if err := os.MkdirAll(templates, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"brief.tmpl", "links.tmpl", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(templates, name), []byte("{{.Output}}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, _ := completeTemplateNames(rootCmd, nil, "")
+	if strings.Join(names, ",") != "brief,links" {
+		t.Errorf("completeTemplateNames() = %v; want [brief links]", names)
+	}
+
+	names, _ = completeTemplateNames(rootCmd, nil, "li")
+	if strings.Join(names, ",") != "links" {
+		t.Errorf("completeTemplateNames(li) = %v; want [links]", names)
+	}
+}
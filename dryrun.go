@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"strings"
+)
+
+const (
+	// --dry-run modes
+	dryRunJSON = "json"
+	dryRunHTTP = "http"
+	dryRunCurl = "curl"
+
+	maskedAPIKey = "***"
+)
+
+// writeDryRun prints the request queryKagi would send, without sending it.
+// The API key is masked unless config.ShowKey is set; curl commands read it
+// from $KAGI_API_KEY instead, so they still run.
+func writeDryRun(w io.Writer, config *Config) error {
+	apiKey := maskedAPIKey
+	if config.ShowKey {
+		apiKey = config.APIKey
+	}
+	req, body, err := newFastGPTRequest(context.Background(), apiKey, config.Query)
+	if err != nil {
+		return err
+	}
+
+	switch config.DryRun {
+	case dryRunHTTP:
+		dump, err := httputil.DumpRequestOut(req, true)
+		if err != nil {
+			return fmt.Errorf("failed to dump request: %w", err)
+		}
+		// The body has no trailing newline; add one for the terminal
+		_, err = w.Write(append(dump, '\n'))
+		return err
+	case dryRunCurl:
+		_, err := io.WriteString(w, curlCommand(req.URL.String(), body, config))
+		return err
+	default:
+		// Indent the body as sent, preserving field order
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "  "); err != nil {
+			return fmt.Errorf("failed to format request: %w", err)
+		}
+		buf.WriteByte('\n')
+		_, err = w.Write(buf.Bytes())
+		return err
+	}
+}
+
+// curlCommand returns a curl command equivalent to the API request,
+// including the transport flags that curl supports.
+func curlCommand(url string, body []byte, config *Config) string {
+	auth := `"Authorization: ` + authHeaderPrefix + `$` + envAPIKey + `"`
+	if config.ShowKey {
+		auth = shellQuote("Authorization: " + authHeaderPrefix + config.APIKey)
+	}
+
+	args := []string{"curl -sS -X POST " + shellQuote(url)}
+	if flagProxy != "" {
+		args = append(args, "--proxy "+shellQuote(flagProxy))
+	}
+	if flagCACert != "" {
+		args = append(args, "--cacert "+shellQuote(flagCACert))
+	}
+	if flagClientCert != "" {
+		args = append(args, "--cert "+shellQuote(flagClientCert), "--key "+shellQuote(flagClientKey))
+	}
+	if flagTLSMinVersion == tlsVersion13 {
+		args = append(args, "--tlsv1.3")
+	}
+	args = append(args,
+		"--max-time "+fmt.Sprint(config.Timeout),
+		"-H "+shellQuote("Content-Type: "+contentTypeJSON),
+		"-H "+auth,
+		"--data-raw "+shellQuote(string(body)),
+	)
+	return strings.Join(args, " \\\n  ") + "\n"
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteDryRun(t *testing.T) {
+	config := &Config{APIKey: "secret-key", Query: "it's golang", Timeout: 10}
+
+	t.Run("json", func(t *testing.T) {
+		config.DryRun = dryRunJSON
+		var out strings.Builder
+		if err := writeDryRun(&out, config); err != nil {
+			t.Fatalf("writeDryRun failed: %v", err)
+		}
+		var req FastGPTRequest
+		if err := json.Unmarshal([]byte(out.String()), &req); err != nil {
+			t.Fatalf("Output is not a FastGPT request: %v\n%s", err, out.String())
+		}
+		if req.Query != "it's golang" || !req.WebSearch || !req.Cache {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+	})
+
+	t.Run("http masks key", func(t *testing.T) {
+		config.DryRun = dryRunHTTP
+		var out strings.Builder
+		if err := writeDryRun(&out, config); err != nil {
+			t.Fatalf("writeDryRun failed: %v", err)
+		}
+		output := out.String()
+		for _, want := range []string{"POST /api/v0/fastgpt HTTP/1.1", "Host: kagi.com", "Authorization: Bot ***", `{"query":"it's golang"`} {
+			if !strings.Contains(output, want) {
+				t.Errorf("HTTP dump missing %q:\n%s", want, output)
+			}
+		}
+		if strings.Contains(output, "secret-key") {
+			t.Errorf("HTTP dump leaks the API key")
+		}
+	})
+
+	t.Run("curl reads key from environment", func(t *testing.T) {
+		config.DryRun = dryRunCurl
+		var out strings.Builder
+		if err := writeDryRun(&out, config); err != nil {
+			t.Fatalf("writeDryRun failed: %v", err)
+		}
+		output := out.String()
+		for _, want := range []string{
+			"curl -sS -X POST 'https://kagi.com/api/v0/fastgpt'",
+			"--max-time 10",
+			`-H "Authorization: Bot $KAGI_API_KEY"`,
+			`--data-raw '{"query":"it'\''s golang","web_search":true,"cache":true}'`,
+		} {
+			if !strings.Contains(output, want) {
+				t.Errorf("curl command missing %q:\n%s", want, output)
+			}
+		}
+		if strings.Contains(output, "secret-key") {
+			t.Errorf("curl command leaks the API key")
+		}
+	})
+
+	t.Run("show key", func(t *testing.T) {
+		config.DryRun = dryRunCurl
+		config.ShowKey = true
+		defer func() { config.ShowKey = false }()
+		var out strings.Builder
+		if err := writeDryRun(&out, config); err != nil {
+			t.Fatalf("writeDryRun failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "-H 'Authorization: Bot secret-key'") {
+			t.Errorf("--show-key should include the key:\n%s", out.String())
+		}
+	})
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":     "'plain'",
+		"it's":      `'it'\''s'`,
+		"$HOME `x`": "'$HOME `x`'",
+	}
+	for input, want := range tests {
+		if got := shellQuote(input); got != want {
+			t.Errorf("shellQuote(%q) = %s; want %s", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	envVisual = "VISUAL"
+	envEditor = "EDITOR"
+
+	// editorShellChars make $EDITOR a shell command rather than a program
+	// name; git uses the same set
+	editorShellChars = "|&;<>()$`\\\"' \t\n*?[#~=%"
+
+	editorHelp = `
+# Write your query above. Lines starting with '#' are ignored, and an
+# empty query aborts. Piped context and attached files are added when the
+# query is sent.
+`
+)
+
+// resolveEditor returns the editor command from $VISUAL or $EDITOR.
+func resolveEditor() string {
+	for _, name := range []string{envVisual, envEditor} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// editQuery opens editor on a temporary file containing prefill and returns
+// the saved contents without comment lines.
+func editQuery(editor, prefill string) (string, error) {
+	file, err := os.CreateTemp("", "kagi-query-*.md")
+	if err != nil {
+		return "", fmt.Errorf("failed to create query file: %w", err)
+	}
+	path := file.Name()
+	defer os.Remove(path)
+
+	if prefill != "" {
+		prefill += "\n"
+	}
+	if _, err := file.WriteString(prefill + editorHelp); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write query file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write query file: %w", err)
+	}
+
+	if err := runEditor(editor, path); err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read query file: %w", err)
+	}
+	query := stripComments(string(content))
+	if query == "" {
+		return "", fmt.Errorf("empty query, aborting")
+	}
+	return query, nil
+}
+
+// runEditor runs editor on path. Like git, a command with arguments or
+// other shell syntax is run by the shell, e.g. "code --wait", and a plain
+// program is run directly, leaving no shell to be killed by Ctrl-C. The
+// editor owns the terminal until it exits, so signals do not stop it from
+// kagi's side.
+func runEditor(editor, path string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		parts := strings.Fields(editor)
+		cmd = exec.Command(parts[0], append(parts[1:], path)...)
+	} else if !strings.ContainsAny(editor, editorShellChars) {
+		cmd = exec.Command(editor, path)
+	} else {
+		cmd = exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	}
+
+	// Stdin may be the piped prefill and stdout the output file, so talk
+	// to the terminal directly where there is one
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		cmd.Stdin, cmd.Stdout = tty, tty
+	}
+
+	done := foregroundChild()
+	defer done()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	return nil
+}
+
+// stripComments removes lines starting with '#', as git commit does, and
+// surrounding whitespace.
+func stripComments(content string) string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scriptEditor writes a shell script that acts as an editor and returns its
+// path. The script receives the query file as $1.
+func scriptEditor(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("editor scripts need a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "editor.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEditQuery(t *testing.T) {
+	t.Run("returns saved contents without comment lines", func(t *testing.T) {
+		editor := scriptEditor(t, `printf 'first line\n# a note\n\nsecond line\n\n# help\n' > "$1"`)
+		query, err := editQuery(editor, "")
+		if err != nil {
+			t.Fatalf("editQuery failed: %v", err)
+		}
+		if query != "first line\n\nsecond line" {
+			t.Errorf("query = %q", query)
+		}
+	})
+
+	t.Run("drops comment lines from the prefill", func(t *testing.T) {
+		prefill := "# Review\n\nIs this idiomatic?\n  # indented lines are not comments"
+		editor := scriptEditor(t, "true")
+		query, err := editQuery(editor, prefill)
+		if err != nil {
+			t.Fatalf("editQuery failed: %v", err)
+		}
+		if query != "Is this idiomatic?\n  # indented lines are not comments" {
+			t.Errorf("query = %q; want the prefill without its # lines", query)
+		}
+	})
+
+	t.Run("prefills the file", func(t *testing.T) {
+		captured := filepath.Join(t.TempDir(), "captured")
+		editor := scriptEditor(t, `cp "$1" '`+captured+`'`)
+		query, err := editQuery(editor, "golang generics")
+		if err != nil {
+			t.Fatalf("editQuery failed: %v", err)
+		}
+		if query != "golang generics" {
+			t.Errorf("Unchanged file should give the prefill, got %q", query)
+		}
+		content, _ := os.ReadFile(captured)
+		if string(content) != "golang generics\n"+editorHelp {
+			t.Errorf("Editor file = %q; want prefill and help comment", content)
+		}
+	})
+
+	t.Run("editor arguments", func(t *testing.T) {
+		editor := scriptEditor(t, `printf '%s\n' "$1" > "$2"`)
+		query, err := editQuery(editor+" --wait", "")
+		if err != nil {
+			t.Fatalf("editQuery failed: %v", err)
+		}
+		if query != "--wait" {
+			t.Errorf("query = %q; want the editor argument passed before the file", query)
+		}
+	})
+
+	t.Run("empty file aborts", func(t *testing.T) {
+		editor := scriptEditor(t, `printf '\n# help\n' > "$1"`)
+		if _, err := editQuery(editor, "prefill"); err == nil || !strings.Contains(err.Error(), "empty query") {
+			t.Errorf("Expected empty query error, got %v", err)
+		}
+	})
+
+	t.Run("editor failure", func(t *testing.T) {
+		editor := scriptEditor(t, "exit 1")
+		if _, err := editQuery(editor, "prefill"); err == nil || !strings.Contains(err.Error(), "failed") {
+			t.Errorf("Expected editor failure, got %v", err)
+		}
+	})
+}
+
+func TestResolveEditor(t *testing.T) {
+	t.Setenv(envVisual, "")
+	t.Setenv(envEditor, "nano")
+	if editor := resolveEditor(); editor != "nano" {
+		t.Errorf("resolveEditor() = %q; want $EDITOR", editor)
+	}
+
+	t.Setenv(envVisual, "code --wait")
+	if editor := resolveEditor(); editor != "code --wait" {
+		t.Errorf("resolveEditor() = %q; $VISUAL should take precedence", editor)
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"help removed", "query\n" + editorHelp, "query"},
+		{"CRLF line endings", "query\r\n# help\r\n", "query"},
+		{"comment lines removed", "# heading\nwhy does this fail?\n# note\nthanks", "why does this fail?\nthanks"},
+		{"indented # kept", "query\n  # kept", "query\n  # kept"},
+		{"only help", editorHelp, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripComments(tt.content); got != tt.want {
+				t.Errorf("stripComments() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEditor(t *testing.T) {
+	t.Setenv(envAPIKey, "test-key")
+	previousEditor, previousFormat := flagEditor, flagFormat
+	flagEditor = true
+	defer func() { flagEditor, flagFormat = previousEditor, previousFormat }()
+	rootCmd.SetContext(context.Background())
+
+	t.Run("flags are validated before the editor opens", func(t *testing.T) {
+		opened := filepath.Join(t.TempDir(), "opened")
+		t.Setenv(envVisual, scriptEditor(t, `touch '`+opened+`'`))
+		flagFormat = "bogus"
+		defer func() { flagFormat = previousFormat }()
+
+		if _, err := loadConfig(rootCmd, []string{"question"}); err == nil || !strings.Contains(err.Error(), "--format") {
+			t.Fatalf("Expected invalid --format error, got %v", err)
+		}
+		if _, err := os.Stat(opened); err == nil {
+			t.Errorf("The editor opened before the invalid flag was reported")
+		}
+	})
+
+	t.Run("stdin context is added after editing", func(t *testing.T) {
+		t.Setenv(envVisual, scriptEditor(t, `printf 'edited question\n' > "$1"`))
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteString("+added line\n")
+		w.Close()
+		previousStdin := os.Stdin
+		os.Stdin = r
+		defer func() { os.Stdin = previousStdin; r.Close() }()
+
+		config, err := loadConfig(rootCmd, []string{"review", "this"})
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if config.Question != "edited question" {
+			t.Errorf("Question = %q; want the edited question", config.Question)
+		}
+		if config.Query != "<context>\n+added line\n</context>\n\nedited question" {
+			t.Errorf("Query = %q; want the context combined with the edited question", config.Query)
+		}
+	})
+
+	t.Run("cancelling does not stop the editor", func(t *testing.T) {
+		// A Ctrl-C meant for the editor also reaches kagi; the editor must
+		// keep running and its query must not be lost
+		t.Setenv(envVisual, scriptEditor(t, `sleep 0.3; printf 'edited question\n' > "$1"`))
+		previousStdin := flagStdin
+		flagStdin = stdinIgnore
+		defer func() { flagStdin = previousStdin }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		rootCmd.SetContext(ctx)
+		defer rootCmd.SetContext(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		config, err := loadConfig(rootCmd, []string{"question"})
+		if err != nil {
+			t.Fatalf("loadConfig failed: %v", err)
+		}
+		if config.Question != "edited question" {
+			t.Errorf("Question = %q; want the edited question", config.Question)
+		}
+	})
+}
+
+func TestHandleSignals(t *testing.T) {
+	signals := make(chan os.Signal)
+	var events []string
+	finished := make(chan struct{})
+	go func() {
+		handleSignals(signals,
+			func() { events = append(events, "cancel") },
+			func() { events = append(events, "exit") })
+		close(finished)
+	}()
+
+	done := foregroundChild()
+	signals <- os.Interrupt
+	signals <- syscall.SIGTERM
+	done()
+	signals <- os.Interrupt
+	close(signals)
+	<-finished
+
+	// Ctrl-C during the child is its own; SIGTERM still cancels
+	if strings.Join(events, " ") != "cancel exit" {
+		t.Errorf("events = %q; want cancel on SIGTERM, then exit on Ctrl-C", events)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatFields_output prints the value at each --field path, one per line.
+// Paths address the API response, e.g. "data.references.0.url", and accept
+// "*" to match every element: "data.references.*.url". jq-style paths such
+// as ".data.references[0].url" and ".data.references[].url" also work.
+func formatFields_output(resp *FastGPTResponse, config *Config) (string, error) {
+	// Prefer the raw body so fields this client does not model can be selected
+	body := resp.Raw
+	if len(body) == 0 {
+		var err error
+		body, err = json.Marshal(resp)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal response to JSON: %w", err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	var output strings.Builder
+	for _, field := range config.Fields {
+		values, err := selectField(doc, field)
+		if err != nil {
+			return "", err
+		}
+		for _, value := range values {
+			text, err := fieldString(value)
+			if err != nil {
+				return "", err
+			}
+			output.WriteString(text)
+			output.WriteString("\n")
+		}
+	}
+
+	return output.String(), nil
+}
+
+// parseFieldPath splits a field path into segments, accepting both
+// dotted (a.0.b, a.*.b) and jq-style (.a[0].b, .a[].b) forms.
+func parseFieldPath(path string) ([]string, error) {
+	normalized := strings.NewReplacer("[]", ".*", "[", ".", "]", "").Replace(strings.TrimSpace(path))
+	normalized = strings.TrimPrefix(normalized, ".")
+	if normalized == "" {
+		return nil, fmt.Errorf("invalid value %q for --field\nExample: --field data.references.0.url", path)
+	}
+
+	segments := strings.Split(normalized, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid value %q for --field\nExample: --field data.references.0.url", path)
+		}
+	}
+	return segments, nil
+}
+
+// selectField returns every value in doc matching path. A path without
+// wildcards must match exactly one value.
+func selectField(doc any, path string) ([]any, error) {
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := []any{doc}
+	for _, segment := range segments {
+		var next []any
+		for _, value := range current {
+			next = append(next, selectSegment(value, segment)...)
+		}
+		current = next
+	}
+
+	if len(current) == 0 && !strings.Contains(path, "*") && !strings.Contains(path, "[]") {
+		return nil, fmt.Errorf("field %q not found in response", path)
+	}
+	return current, nil
+}
+
+func selectSegment(value any, segment string) []any {
+	switch v := value.(type) {
+	case map[string]any:
+		if segment == "*" {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			values := make([]any, len(keys))
+			for i, key := range keys {
+				values[i] = v[key]
+			}
+			return values
+		}
+		if child, ok := v[segment]; ok {
+			return []any{child}
+		}
+	case []any:
+		if segment == "*" {
+			return v
+		}
+		index, err := strconv.Atoi(segment)
+		if err != nil {
+			return nil
+		}
+		// Negative indexes count from the end, as in jq
+		if index < 0 {
+			index += len(v)
+		}
+		if index >= 0 && index < len(v) {
+			return []any{v[index]}
+		}
+	}
+	return nil
+}
+
+// fieldString formats a selected value for output: strings as-is, anything
+// else as compact JSON.
+func fieldString(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal field value: %w", err)
+	}
+	return string(jsonBytes), nil
+}
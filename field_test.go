@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{"data.output", "data/output", true},
+		{"data.references.0.url", "data/references/0/url", true},
+		{"data.references.*.url", "data/references/*/url", true},
+		{".data.references[0].url", "data/references/0/url", true},
+		{".data.references[].url", "data/references/*/url", true},
+		{"", "", false},
+		{".", "", false},
+		{"data..output", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			segments, err := parseFieldPath(tt.path)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("parseFieldPath(%q) should fail", tt.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFieldPath(%q) failed: %v", tt.path, err)
+			}
+			if result := strings.Join(segments, "/"); result != tt.expected {
+				t.Errorf("parseFieldPath(%q) = %q; want %q", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatFields_output(t *testing.T) {
+	resp := createTestResponse()
+
+	tests := []struct {
+		name     string
+		fields   []string
+		expected string
+	}{
+		{"string value", []string{"data.output"}, "This is a test response\n"},
+		{"number value", []string{"data.tokens"}, "50\n"},
+		{"indexed reference", []string{"data.references.0.url"}, "https://example.com/1\n"},
+		{"negative index", []string{"data.references.-1.title"}, "Test Reference 2\n"},
+		{"wildcard", []string{"data.references.*.url"}, "https://example.com/1\nhttps://example.com/2\n"},
+		{"jq-style wildcard", []string{".data.references[].title"}, "Test Reference 1\nTest Reference 2\n"},
+		{"object as JSON", []string{"meta"}, `{"id":"test-id","ms":100,"node":"test-node"}` + "\n"},
+		{"multiple fields in order", []string{"meta.ms", "data.tokens"}, "100\n50\n"},
+		{"wildcard with no matches", []string{"data.references.*.favicon"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := formatFields_output(resp, &Config{Fields: tt.fields})
+			if err != nil {
+				t.Fatalf("formatFields_output failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("formatFields_output(%v) = %q; want %q", tt.fields, result, tt.expected)
+			}
+		})
+	}
+
+	t.Run("missing field is an error", func(t *testing.T) {
+		_, err := formatFields_output(resp, &Config{Fields: []string{"data.references.5.url"}})
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected not found error, got: %v", err)
+		}
+	})
+
+	t.Run("raw body fields are selectable", func(t *testing.T) {
+		rawResp := createTestResponse()
+		rawResp.Raw = []byte(`{"meta":{"region":"au"},"data":{"output":"x"}}`)
+
+		result, err := formatFields_output(rawResp, &Config{Fields: []string{"meta.region"}})
+		if err != nil {
+			t.Fatalf("formatFields_output failed: %v", err)
+		}
+		if result != "au\n" {
+			t.Errorf("formatFields_output() = %q; want %q", result, "au\n")
+		}
+	})
+}
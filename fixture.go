@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// replayAPIKey stands in for the API key when replaying without one; the
+// key is scrubbed from fixtures, so it never affects matching.
+const replayAPIKey = "replay"
+
+// fixture is a recorded request and response pair, stored as one JSON file
+// per request in the --record directory.
+type fixture struct {
+	Request  fixtureRequest  `json:"request"`
+	Response fixtureResponse `json:"response"`
+}
+
+type fixtureRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+type fixtureResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// fixtureName returns the file name for a request. Requests match on
+// method, URL and body; headers are ignored, so fixtures recorded with one
+// API key replay with any other.
+func fixtureName(method, rawURL string, body []byte) string {
+	// Compact JSON so formatting differences do not change the match
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, rawURL)
+	hash.Write(body)
+
+	name := "request"
+	if base := slugify(path.Base(rawURL)); base != "" {
+		name = base
+	}
+	return name + "-" + hex.EncodeToString(hash.Sum(nil))[:16] + ".json"
+}
+
+// readRequestBody reads and restores the request body.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordTransport sends requests through next and saves each exchange to
+// dir with credentials scrubbed.
+type recordTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	recorded := fixture{
+		Request: fixtureRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: redactHeaders(req.Header),
+			Body:    string(reqBody),
+		},
+		Response: fixtureResponse{
+			Status:  resp.StatusCode,
+			Headers: redactHeaders(resp.Header),
+			Body:    string(respBody),
+		},
+	}
+	jsonBytes, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal fixture: %w", err)
+	}
+
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	name := filepath.Join(t.dir, fixtureName(req.Method, req.URL.String(), reqBody))
+	if err := os.WriteFile(name, append(jsonBytes, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	return resp, nil
+}
+
+// fixtureMissError reports a replayed request that has no fixture.
+type fixtureMissError struct {
+	dir  string
+	name string
+}
+
+func (e *fixtureMissError) Error() string {
+	return fmt.Sprintf("no recorded response for this request in %s (expected %s)\nRecord it with --record %s", e.dir, e.name, e.dir)
+}
+
+// replayTransport answers requests from fixtures in dir without touching
+// the network.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	name := fixtureName(req.Method, req.URL.String(), reqBody)
+	content, err := os.ReadFile(filepath.Join(t.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &fixtureMissError{dir: t.dir, name: name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var recorded fixture
+	if err := json.Unmarshal(content, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+
+	status := recorded.Response.Status
+	headers := recorded.Response.Headers
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          io.NopCloser(strings.NewReader(recorded.Response.Body)),
+		ContentLength: int64(len(recorded.Response.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// useHTTPClient swaps httpClient for the duration of a test.
+func useHTTPClient(t *testing.T, client *http.Client) {
+	t.Helper()
+	previous := httpClient
+	httpClient = client
+	t.Cleanup(func() { httpClient = previous })
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	upstreamCalls := 0
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		upstreamCalls++
+		body := `{"meta":{"id":"abc","node":"us-east","ms":900},"data":{"output":"Recorded answer","tokens":12,"references":[]}}`
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     http.Header{"Content-Type": {contentTypeJSON}, "Set-Cookie": {"session=secret-cookie"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+
+	useHTTPClient(t, &http.Client{Transport: &recordTransport{dir: dir, next: upstream}})
+	recorded, err := queryKagi(context.Background(), "secret-key", "golang", defaultTimeout)
+	if err != nil {
+		t.Fatalf("Recording query failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "fastgpt-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 fixture, got %v", files)
+	}
+	content, _ := os.ReadFile(files[0])
+	if strings.Contains(string(content), "secret-key") || strings.Contains(string(content), "secret-cookie") {
+		t.Errorf("Fixture leaks credentials:\n%s", content)
+	}
+	if !strings.Contains(string(content), `"Bot ***"`) {
+		t.Errorf("Fixture should keep the scrubbed Authorization header:\n%s", content)
+	}
+
+	// Replay with a different key and no upstream at all
+	useHTTPClient(t, &http.Client{Transport: &replayTransport{dir: dir}})
+	replayed, err := queryKagi(context.Background(), replayAPIKey, "golang", defaultTimeout)
+	if err != nil {
+		t.Fatalf("Replaying query failed: %v", err)
+	}
+	if upstreamCalls != 1 {
+		t.Errorf("Upstream calls = %d; replay must not reach the network", upstreamCalls)
+	}
+	if replayed.Data.Output != recorded.Data.Output || replayed.Meta.ID != "abc" {
+		t.Errorf("Replayed response differs: %+v", replayed)
+	}
+
+	_, err = queryKagi(context.Background(), replayAPIKey, "rust", defaultTimeout)
+	if err == nil || !strings.Contains(err.Error(), "no recorded response") || !strings.Contains(err.Error(), "--record "+dir) {
+		t.Errorf("Expected clear miss error, got %v", err)
+	}
+}
+
+func TestReplayErrorResponse(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte(`{"query":"golang","web_search":true,"cache":true}`)
+	fixtureJSON := `{
+  "request": {"method": "POST", "url": "` + apiEndpoint + `", "body": "{}"},
+  "response": {"status": 429, "body": "{\"error\":[{\"code\":1,\"msg\":\"Too many requests\"}]}"}
+}`
+	name := filepath.Join(dir, fixtureName(http.MethodPost, apiEndpoint, body))
+	if err := os.WriteFile(name, []byte(fixtureJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	useHTTPClient(t, &http.Client{Transport: &replayTransport{dir: dir}})
+	_, err := queryKagi(context.Background(), replayAPIKey, "golang", defaultTimeout)
+	if err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Errorf("Replayed 429 should surface as a rate limit error, got %v", err)
+	}
+}
+
+func TestReplayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	useHTTPClient(t, &http.Client{Transport: &replayTransport{dir: t.TempDir()}})
+	_, err := queryKagi(ctx, replayAPIKey, "golang", defaultTimeout)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("queryKagi() = %v; want context.Canceled", err)
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	a := fixtureName(http.MethodPost, apiEndpoint, []byte(`{"query": "golang"}`))
+	b := fixtureName(http.MethodPost, apiEndpoint, []byte(`{"query":"golang"}`))
+	c := fixtureName(http.MethodPost, apiEndpoint, []byte(`{"query":"rust"}`))
+	if a != b {
+		t.Errorf("JSON formatting should not change the match: %s != %s", a, b)
+	}
+	if a == c {
+		t.Errorf("Different bodies should not match")
+	}
+	if !strings.HasPrefix(a, "fastgpt-") || !strings.HasSuffix(a, ".json") {
+		t.Errorf("fixtureName = %q; want fastgpt-<hash>.json", a)
+	}
+}
+
+func TestRecordReplayFlags(t *testing.T) {
+	if _, err := newHTTPClient(transportOptions{Record: t.TempDir(), Replay: t.TempDir()}); err == nil {
+		t.Errorf("Expected error for --record with --replay")
+	}
+	if _, err := newHTTPClient(transportOptions{Replay: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Errorf("Expected error for missing --replay directory")
+	}
+}
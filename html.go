@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// htmlStyle is embedded in standalone pages so they render without external assets.
+const htmlStyle = `body {
+  max-width: 48rem;
+  margin: 2rem auto;
+  padding: 0 1rem;
+  font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+  line-height: 1.6;
+  color: #1f2328;
+}
+h1 { font-size: 1.6rem; border-bottom: 1px solid #d0d7de; padding-bottom: 0.3rem; }
+h2 { font-size: 1.25rem; margin-top: 2rem; }
+a { color: #0969da; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 0.9em; background: #f6f8fa; padding: 0.1em 0.3em; border-radius: 4px; }
+pre { background: #f6f8fa; padding: 1rem; overflow-x: auto; border-radius: 6px; }
+pre code { background: none; padding: 0; }
+blockquote { margin: 0.25rem 0 0.75rem; padding-left: 1rem; border-left: 3px solid #d0d7de; color: #59636e; }
+sup.citation a { text-decoration: none; }
+.kagi-references li { margin-bottom: 0.5rem; }
+`
+
+// formatHTML_output renders the response as HTML. By default it is a fragment
+// suitable for pasting into an existing page; with --standalone it is a
+// complete, self-contained document.
+func formatHTML_output(resp *FastGPTResponse, config *Config) string {
+	var body strings.Builder
+
+	if config.Quiet {
+		// There is no references list for citations to link to, so they
+		// stay plain text
+		body.WriteString(markdownToHTML(resp.Data.Output, nil))
+	} else {
+		body.WriteString("<article class=\"kagi-answer\">\n")
+		body.WriteString("<h1>")
+		body.WriteString(html.EscapeString(config.Question))
+		body.WriteString("</h1>\n")
+
+		body.WriteString("<div class=\"kagi-output\">\n")
+		body.WriteString(markdownToHTML(resp.Data.Output, resp.Data.References))
+		body.WriteString("</div>\n")
+
+		if len(resp.Data.References) > 0 {
+			body.WriteString("<section class=\"kagi-references\">\n<h2>References</h2>\n<ol>\n")
+
+			for i, ref := range resp.Data.References {
+				body.WriteString(fmt.Sprintf("<li id=\"ref-%d\">", i+1))
+				body.WriteString(htmlLink(html.EscapeString(ref.Title), ref.URL))
+
+				if ref.Snippet != "" {
+					body.WriteString("\n<blockquote>")
+					body.WriteString(html.EscapeString(ref.Snippet))
+					body.WriteString("</blockquote>\n")
+				}
+
+				body.WriteString("</li>\n")
+			}
+
+			body.WriteString("</ol>\n</section>\n")
+		}
+
+		body.WriteString("</article>\n")
+	}
+
+	if !config.Standalone {
+		return body.String()
+	}
+
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n")
+	page.WriteString("<meta charset=\"utf-8\">\n")
+	page.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	page.WriteString("<meta name=\"generator\" content=\"kagi ")
+	page.WriteString(html.EscapeString(version))
+	page.WriteString("\">\n<title>")
+	page.WriteString(html.EscapeString(config.Question))
+	page.WriteString("</title>\n<style>\n")
+	page.WriteString(htmlStyle)
+	page.WriteString("</style>\n</head>\n<body>\n")
+	page.WriteString(body.String())
+	page.WriteString("</body>\n</html>\n")
+
+	return page.String()
+}
+
+// markdownToHTML converts the answer markdown to HTML, linking citation
+// markers to the matching entry in the references list. Without refs the
+// markers are left as text.
+func markdownToHTML(src string, refs []Reference) string {
+	var out strings.Builder
+
+	for _, block := range parseMarkdown(src) {
+		switch block.Kind {
+		case mdHeading:
+			// The query is the page's h1, so answer headings start at h2
+			level := min(block.Level+1, 6)
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, inlineToHTML(block.Text, refs), level)
+		case mdCodeBlock:
+			out.WriteString("<pre><code")
+			if block.Lang != "" {
+				out.WriteString(" class=\"language-")
+				out.WriteString(html.EscapeString(block.Lang))
+				out.WriteString("\"")
+			}
+			out.WriteString(">")
+			out.WriteString(html.EscapeString(block.Text))
+			out.WriteString("</code></pre>\n")
+		case mdList:
+			tag := "ul"
+			if block.Ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for _, item := range block.Items {
+				out.WriteString("<li>")
+				out.WriteString(inlineToHTML(item, refs))
+				out.WriteString("</li>\n")
+			}
+			out.WriteString("</" + tag + ">\n")
+		case mdQuote:
+			out.WriteString("<blockquote>")
+			out.WriteString(inlineToHTML(block.Text, refs))
+			out.WriteString("</blockquote>\n")
+		case mdRule:
+			out.WriteString("<hr>\n")
+		default:
+			out.WriteString("<p>")
+			out.WriteString(inlineToHTML(block.Text, refs))
+			out.WriteString("</p>\n")
+		}
+	}
+
+	return out.String()
+}
+
+func inlineToHTML(src string, refs []Reference) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		switch span.Kind {
+		case mdBold:
+			out.WriteString("<strong>" + htmlCitations(span.Text, refs) + "</strong>")
+		case mdItalic:
+			out.WriteString("<em>" + htmlCitations(span.Text, refs) + "</em>")
+		case mdCode:
+			out.WriteString("<code>" + html.EscapeString(span.Text) + "</code>")
+		case mdLink:
+			out.WriteString(htmlLink(html.EscapeString(span.Text), span.URL))
+		default:
+			out.WriteString(htmlCitations(span.Text, refs))
+		}
+	}
+
+	return out.String()
+}
+
+// htmlCitations escapes text and turns citation markers into links to the
+// references list.
+func htmlCitations(text string, refs []Reference) string {
+	var out strings.Builder
+	last := 0
+
+	for _, m := range citationPattern.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(html.EscapeString(text[last:m[0]]))
+		if idx, err := strconv.Atoi(text[m[2]:m[3]]); err == nil && idx >= 1 && idx <= len(refs) {
+			fmt.Fprintf(&out, "<sup class=\"citation\"><a href=\"#ref-%d\">[%d]</a></sup>", idx, idx)
+		} else {
+			out.WriteString(html.EscapeString(text[m[0]:m[1]]))
+		}
+		last = m[1]
+	}
+	out.WriteString(html.EscapeString(text[last:]))
+
+	return out.String()
+}
+
+// htmlLink wraps already-escaped text in an anchor, dropping the link when
+// the URL uses a scheme that could run script in the reader's browser.
+func htmlLink(escapedText, rawURL string) string {
+	href := safeURL(rawURL)
+	if href == "" {
+		return escapedText
+	}
+	return "<a href=\"" + html.EscapeString(href) + "\">" + escapedText + "</a>"
+}
+
+// safeURL returns rawURL if it is an absolute http(s) or mailto URL, or "" otherwise.
+func safeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return u.String()
+	default:
+		return ""
+	}
+}
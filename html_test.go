@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatHTML_output(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("fragment output", func(t *testing.T) {
+		config := &Config{
+			Question: "test query",
+			Format:   formatHTML,
+		}
+
+		result := formatHTML_output(resp, config)
+
+		if !strings.Contains(result, "<h1>test query</h1>") {
+			t.Errorf("HTML output missing heading")
+		}
+		if !strings.Contains(result, "<p>This is a test response</p>") {
+			t.Errorf("HTML output missing response paragraph")
+		}
+		if !strings.Contains(result, `<li id="ref-1"><a href="https://example.com/1">Test Reference 1</a>`) {
+			t.Errorf("HTML output missing linked reference")
+		}
+		if !strings.Contains(result, "<blockquote>First test snippet</blockquote>") {
+			t.Errorf("HTML output missing snippet")
+		}
+		if strings.Contains(result, "<!DOCTYPE html>") || strings.Contains(result, "<style>") {
+			t.Errorf("Fragment output should not include document wrapper")
+		}
+	})
+
+	t.Run("standalone output", func(t *testing.T) {
+		config := &Config{
+			Question:   "test query",
+			Format:     formatHTML,
+			Standalone: true,
+		}
+
+		result := formatHTML_output(resp, config)
+
+		if !strings.HasPrefix(result, "<!DOCTYPE html>") {
+			t.Errorf("Standalone output should start with doctype")
+		}
+		if !strings.Contains(result, "<title>test query</title>") {
+			t.Errorf("Standalone output missing title")
+		}
+		if !strings.Contains(result, "<style>") {
+			t.Errorf("Standalone output missing embedded CSS")
+		}
+		if !strings.HasSuffix(result, "</html>\n") {
+			t.Errorf("Standalone output should end with closing html tag")
+		}
+	})
+
+	t.Run("quiet mode outputs body only", func(t *testing.T) {
+		config := &Config{
+			Question: "test query",
+			Format:   formatHTML,
+			Quiet:    true,
+		}
+
+		result := formatHTML_output(resp, config)
+
+		if result != "<p>This is a test response</p>\n" {
+			t.Errorf("Quiet HTML output = %q", result)
+		}
+	})
+
+	t.Run("quiet mode leaves citations unlinked", func(t *testing.T) {
+		respCited := createTestResponse()
+		respCited.Data.Output = "Cited 【1】"
+		config := &Config{
+			Question: "test query",
+			Format:   formatHTML,
+			Quiet:    true,
+		}
+
+		result := formatHTML_output(respCited, config)
+
+		if result != "<p>Cited 【1】</p>\n" {
+			t.Errorf("Quiet HTML output = %q; want the citation as plain text", result)
+		}
+	})
+
+	t.Run("citations link to the references list", func(t *testing.T) {
+		respCited := createTestResponse()
+		respCited.Data.Output = "Cited 【1】"
+		config := &Config{
+			Question: "test query",
+			Format:   formatHTML,
+		}
+
+		result := formatHTML_output(respCited, config)
+
+		if !strings.Contains(result, `<a href="#ref-1">[1]</a>`) || !strings.Contains(result, `<li id="ref-1">`) {
+			t.Errorf("Citation should link to the reference entry: %q", result)
+		}
+	})
+
+	t.Run("API-provided text is escaped", func(t *testing.T) {
+		respXSS := createTestResponse()
+		respXSS.Data.Output = "<script>alert(1)</script> 【1】"
+		respXSS.Data.References[0].Title = `<img src=x onerror="alert(1)">`
+		respXSS.Data.References[0].Snippet = "a & b <b>"
+		respXSS.Data.References[1].URL = "javascript:alert(1)"
+
+		config := &Config{
+			Question: `<b>"query"</b>`,
+			Format:   formatHTML,
+		}
+
+		result := formatHTML_output(respXSS, config)
+
+		if strings.Contains(result, "<script>") || strings.Contains(result, "<img") || strings.Contains(result, "<b>") {
+			t.Errorf("HTML output contains unescaped markup: %s", result)
+		}
+		if !strings.Contains(result, "&lt;script&gt;") {
+			t.Errorf("Answer text should be escaped")
+		}
+		if !strings.Contains(result, "a &amp; b &lt;b&gt;") {
+			t.Errorf("Snippet should be escaped")
+		}
+		if strings.Contains(result, "javascript:") {
+			t.Errorf("Unsafe URL should not be linked")
+		}
+		if !strings.Contains(result, `<sup class="citation"><a href="#ref-1">[1]</a></sup>`) {
+			t.Errorf("Citation marker should link to reference")
+		}
+	})
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"paragraph with emphasis", "**Go** is *fast*", "<p><strong>Go</strong> is <em>fast</em></p>\n"},
+		{"heading shifted below page title", "## Usage", "<h3>Usage</h3>\n"},
+		{"code block escaped", "```go\na < b\n```", "<pre><code class=\"language-go\">a &lt; b</code></pre>\n"},
+		{"bullet list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"numbered list", "1. a\n2. b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"inline link", "[Go](https://go.dev)", "<p><a href=\"https://go.dev\">Go</a></p>\n"},
+		{"unknown citation left as text", "fact 【9】", "<p>fact 【9】</p>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := markdownToHTML(tt.input, nil)
+			if result != tt.expected {
+				t.Errorf("markdownToHTML(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://example.com/a?b=c&d=e", "https://example.com/a?b=c&d=e"},
+		{"http://example.com", "http://example.com"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+		{"javascript:alert(1)", ""},
+		{"JavaScript:alert(1)", ""},
+		{"data:text/html,<script>", ""},
+		{"/relative/path", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := safeURL(tt.input); result != tt.expected {
+				t.Errorf("safeURL(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
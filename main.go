@@ -10,11 +10,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"golang.org/x/term"
 )
 
@@ -43,14 +49,32 @@ const (
 	formatText     = "text"
 	formatMarkdown = "md"
 	formatJSON     = "json"
+	formatJSONL    = "jsonl"
+	formatHTML     = "html"
+	formatOrg      = "org"
+	formatAsciiDoc = "adoc"
+	formatRST      = "rst"
+
+	// Chat platform output formats
+	formatSlack       = "slack"
+	formatSlackBlocks = "slack-blocks"
+	formatDiscord     = "discord"
+	formatTelegram    = "telegram"
 
 	// Color modes
 	colorAuto   = "auto"
 	colorAlways = "always"
 	colorNever  = "never"
 
+	// Hyperlink modes
+	hyperlinksAuto   = "auto"
+	hyperlinksAlways = "always"
+	hyperlinksNever  = "never"
+
 	// Environment variables
-	envAPIKey = "KAGI_API_KEY"
+	envAPIKey    = "KAGI_API_KEY"
+	envConfigDir = "KAGI_CONFIG_DIR"
+	envEndpoint  = "KAGI_ENDPOINT"
 )
 
 const helpTemplate = `USAGE:
@@ -60,7 +84,10 @@ DESCRIPTION:
   kagi queries the Kagi FastGPT API and returns AI-powered search results
   with web context. Designed for both human users and AI agents.
 
-  Output formats: text (default), markdown (md), or JSON.
+  Output formats: text (default), markdown (md), JSON, JSON Lines (jsonl),
+  HTML, Org (org), AsciiDoc (adoc) or reStructuredText (rst). Chat formats
+  for Slack (slack, slack-blocks), Discord and Telegram split long answers
+  into messages.
 
   API key: Set KAGI_API_KEY environment variable or use --api-key flag.
 
@@ -71,25 +98,87 @@ EXAMPLES:
   # Different output formats
   kagi -f md golang best practices
   kagi -f json golang concurrency > result.json
+  kagi -f html --standalone golang generics > answer.html
 
-  # Using stdin
+  # Using stdin, alone or as context for a question
   echo "explain kubernetes" | kagi
+  git diff | kagi review this change
 
   # With options
   kagi --heading --timeout 60 golang generics
   kagi -q golang channels              # Quiet mode (output body only)
 
+  # Save to a file (format from extension)
+  kagi -o generics.md golang generics
+  kagi -o auto --tee -f md golang generics
+
+COMMANDS:
+  kagi schema              Print the JSON Schema for json and jsonl output
+  kagi mcp                 Run a Model Context Protocol server on stdio
+  kagi serve               Run a local HTTP gateway to FastGPT
+  kagi mock-server         Run a mock FastGPT API for integration testing
+  kagi prompts             List, show and edit prompt templates
+  kagi completion <shell>  Generate a completion script: bash | zsh | fish | powershell
+
 OPTIONS:
-  -f, --format string      Output format: text (txt) | md (markdown) | json (default "text")
+  -f, --format string      Output format: text (txt) | md (markdown) | json | jsonl | html |
+                           org | adoc (asciidoc) | rst | slack | slack-blocks |
+                           discord | telegram (default "text")
+      --standalone         Output a complete HTML page with embedded CSS (html format)
+      --print0             Separate split slack, discord and telegram messages with a
+                           NUL byte instead of a --- line
+      --raw[=mode]         Output the API response body as received: exact | pretty
+      --template string    Render output with a Go template, or a named template
+      --template-file path Render output with a Go template read from a file
+      --field path         Print the value at a response path, e.g. data.references.0.url
+                           (repeatable, * matches every element)
   -q, --quiet              Output only response body (no heading or references)
       --heading            Include query as heading in text format
   -t, --timeout int        HTTP request timeout in seconds (default 30)
   -c, --color string       Color output: auto | always | never (default "auto")
+      --hyperlinks string  Clickable reference links: auto | always | never (default "auto")
+      --no-pager           Do not pipe long terminal output through a pager
+
+  -p, --prompt name        Build the query from a prompt template in the config directory;
+                           arguments are its variables, e.g. kagi -p compare a=go b=rust
+  -e, --editor             Compose the query in $VISUAL or $EDITOR, starting from any
+                           query in arguments or stdin
+      --file path          Attach a file as context, labelled with its path and language
+                           (repeatable, globs like 'src/*.go' are expanded)
+      --file-budget n      Maximum characters of attached file content; larger files are
+                           cut in the middle (default 30000)
+      --stdin mode         Piped input with a question in arguments: auto | context |
+                           query | ignore (default "auto", stdin is context)
+      --stdin-template t   Go template combining {{.Context}} (stdin) and {{.Question}}
+                           (arguments) (default: stdin in <context> tags, then question)
+
+  -o, --output path        Write output to a file; format inferred from .md, .json,
+                           .html, .txt, ... or use "auto" to name it after the query
+      --force              Overwrite the --output file if it exists
+      --tee                Also print text output to the terminal when using --output
 
       --api-key string     Kagi API key (overrides KAGI_API_KEY env var)
 
+      --endpoint url       FastGPT API URL, e.g. a kagi mock-server or kagi serve
+                           (default from KAGI_ENDPOINT, or the Kagi API)
+      --proxy url          Proxy URL (default from HTTPS_PROXY / NO_PROXY)
+      --ca-cert path       PEM file of extra CA certificates to trust
+      --client-cert path   PEM client certificate for mutual TLS (with --client-key)
+      --client-key path    PEM private key for --client-cert
+      --tls-min-version v  Minimum TLS version: 1.2 | 1.3 (default "1.2")
+      --connect-timeout d  TCP connect timeout, e.g. 5s (default 30s)
+      --tls-timeout d      TLS handshake timeout (default 10s)
+      --header-timeout d   Timeout waiting for response headers (default none)
+      --record dir         Save each request and response as a JSON fixture in dir
+      --replay dir         Answer requests from fixtures in dir, without network access
+
+      --dry-run[=mode]     Print the API request instead of sending it: json | http | curl
+      --show-key           Show the API key in --dry-run output instead of masking it
+
       --verbose            Output process information to stderr
-      --debug              Output detailed debug information to stderr
+      --debug              Output detailed debug information to stderr, including
+                           DNS, connect, TLS and time-to-first-byte timings
+      --trace-file path    Write the HTTP trace as JSON to a file
 
   -h, --help               Display this help message
   -v, --version            Display version information
@@ -112,6 +201,11 @@ type FastGPTResponse struct {
 		Tokens     int         `json:"tokens"`
 		References []Reference `json:"references"`
 	} `json:"data"`
+
+	// Raw is the response body exactly as received from the API
+	Raw []byte `json:"-"`
+	// UnknownFields lists fields in Raw that this struct does not model
+	UnknownFields []string `json:"-"`
 }
 
 type FastGPTError struct {
@@ -128,27 +222,64 @@ type Reference struct {
 }
 
 type Config struct {
-	APIKey  string
-	Query   string
-	Format  string
-	Timeout int
-	Heading bool
-	Quiet   bool
-	Color   string
-	Verbose bool
-	Debug   bool
+	APIKey string
+	// Question is the question as asked, shown in headings and titles and
+	// used to name files. Query is the text sent to the API, which adds any
+	// stdin context and attached files.
+	Question   string
+	Query      string
+	Files      []attachment
+	Format     string
+	Timeout    int
+	Heading    bool
+	Quiet      bool
+	Standalone bool
+	Print0     bool
+	Raw        string
+	Template   *template.Template
+	Fields     []string
+	OutputPath string
+	Force      bool
+	Tee        bool
+	Color      string
+	Hyperlinks string
+	NoPager    bool
+	TraceFile  string
+	DryRun     string
+	ShowKey    bool
+	Verbose    bool
+	Debug      bool
 }
 
 var (
-	flagAPIKey  string
-	flagFormat  string
-	flagTimeout int
-	flagHeading bool
-	flagQuiet   bool
-	flagColor   string
-	flagVerbose bool
-	flagDebug   bool
-	flagVersion bool
+	flagAPIKey        string
+	flagFormat        string
+	flagTimeout       int
+	flagHeading       bool
+	flagQuiet         bool
+	flagStandalone    bool
+	flagPrint0        bool
+	flagRaw           string
+	flagTemplate      string
+	flagTemplateFile  string
+	flagFields        []string
+	flagOutput        string
+	flagStdin         string
+	flagEditor        bool
+	flagFiles         []string
+	flagFileBudget    int
+	flagStdinTemplate string
+	flagForce         bool
+	flagTee           bool
+	flagColor         string
+	flagHyperlinks    string
+	flagNoPager       bool
+	flagTraceFile     string
+	flagDryRun        string
+	flagShowKey       bool
+	flagVerbose       bool
+	flagDebug         bool
+	flagVersion       bool
 )
 
 var rootCmd = &cobra.Command{
@@ -157,38 +288,196 @@ var rootCmd = &cobra.Command{
 	Args:         cobra.ArbitraryArgs,
 	RunE:         runCobra,
 	SilenceUsage: true,
+	// main prints errors, so that cancellation is reported as such
+	SilenceErrors: true,
 }
 
 func init() {
 	rootCmd.Flags().StringVar(&flagAPIKey, "api-key", "", "Kagi API key (overrides KAGI_API_KEY env var)")
-	rootCmd.Flags().StringVarP(&flagFormat, "format", "f", formatText, "Output format: text | txt | md | markdown | json")
+	rootCmd.Flags().StringVarP(&flagFormat, "format", "f", formatText, "Output format: text | txt | md | markdown | json | jsonl | html | org | adoc | asciidoc | rst | slack | slack-blocks | discord | telegram")
 	rootCmd.Flags().IntVarP(&flagTimeout, "timeout", "t", defaultTimeout, "HTTP request timeout in seconds")
 	rootCmd.Flags().BoolVar(&flagHeading, "heading", false, "Include query as heading in text format")
 	rootCmd.Flags().BoolVarP(&flagQuiet, "quiet", "q", false, "Output only response body (no heading or references)")
+	rootCmd.Flags().BoolVar(&flagStandalone, "standalone", false, "Output a complete HTML page with embedded CSS (html format)")
+	rootCmd.Flags().BoolVar(&flagPrint0, "print0", false, "Separate split chat messages with a NUL byte instead of a --- line")
+	rootCmd.Flags().StringVar(&flagRaw, "raw", "", "Output the API response body as received: exact | pretty")
+	rootCmd.Flags().Lookup("raw").NoOptDefVal = rawExact
+	rootCmd.Flags().StringVar(&flagTemplate, "template", "", "Render output with a Go template, or a named template")
+	rootCmd.Flags().StringVar(&flagTemplateFile, "template-file", "", "Render output with a Go template read from a file")
+	rootCmd.Flags().StringVar(&flagStdin, "stdin", stdinAuto, "How to use piped input: auto | context | query | ignore")
+	rootCmd.Flags().StringVar(&flagStdinTemplate, "stdin-template", defaultStdinTemplate, "Go template combining stdin ({{.Context}}) with the question in arguments ({{.Question}})")
+	rootCmd.Flags().StringVarP(&flagPrompt, "prompt", "p", "", "Build the query from a prompt template, with name=value arguments")
+	rootCmd.Flags().BoolVarP(&flagEditor, "editor", "e", false, "Compose the query in $VISUAL or $EDITOR")
+	rootCmd.Flags().StringArrayVar(&flagFiles, "file", nil, "Attach a file, or files matching a glob, as context (repeatable)")
+	rootCmd.Flags().IntVar(&flagFileBudget, "file-budget", defaultFileBudget, "Maximum characters of attached file content")
+	rootCmd.Flags().StringVarP(&flagOutput, "output", "o", "", "Write output to a file (format inferred from extension), or auto")
+	rootCmd.Flags().BoolVar(&flagForce, "force", false, "Overwrite the --output file if it exists")
+	rootCmd.Flags().BoolVar(&flagTee, "tee", false, "Also print text output to the terminal when using --output")
+	rootCmd.Flags().StringArrayVar(&flagFields, "field", nil, "Print the value at a response path, e.g. data.references.0.url (repeatable)")
 	rootCmd.Flags().StringVarP(&flagColor, "color", "c", colorAuto, "Color output: auto | always | never")
+	rootCmd.Flags().StringVar(&flagHyperlinks, "hyperlinks", hyperlinksAuto, "Clickable reference links: auto | always | never")
+	rootCmd.Flags().BoolVar(&flagNoPager, "no-pager", false, "Do not pipe long terminal output through a pager")
+	addTransportFlags(rootCmd)
+	rootCmd.Flags().StringVar(&flagDryRun, "dry-run", "", "Print the API request instead of sending it: json | http | curl")
+	rootCmd.Flags().Lookup("dry-run").NoOptDefVal = dryRunJSON
+	rootCmd.Flags().BoolVar(&flagShowKey, "show-key", false, "Show the API key in --dry-run output instead of masking it")
 	rootCmd.Flags().BoolVar(&flagVerbose, "verbose", false, "Output process information to stderr")
 	rootCmd.Flags().BoolVar(&flagDebug, "debug", false, "Output detailed debug information to stderr")
+	rootCmd.Flags().StringVar(&flagTraceFile, "trace-file", "", "Write HTTP request timings and headers as JSON to a file")
 	rootCmd.Flags().BoolVarP(&flagVersion, "version", "v", false, "Display version information")
 
-	rootCmd.SetHelpTemplate(helpTemplate)
+	// The hand-written help applies to the root command only; subcommands
+	// use cobra's generated help
+	defaultHelp := rootCmd.HelpFunc()
+	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		if cmd == rootCmd {
+			fmt.Fprint(cmd.OutOrStdout(), helpTemplate)
+			return
+		}
+		defaultHelp(cmd, args)
+	})
+
+	// Completions are a convenience, so a registration failure must not
+	// stop kagi from running
+	if err := registerCompletions(rootCmd); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
 }
 
 func main() {
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
+	// The first SIGINT or SIGTERM cancels the context, stopping the request
+	// and letting deferred cleanup run; a second one exits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	go func() {
-		<-sigChan
-		// Clean exit on interrupt
-		os.Exit(exitInterrupt)
-	}()
+	go handleSignals(sigChan, cancel, func() { os.Exit(exitInterrupt) })
 
-	if err := rootCmd.Execute(); err != nil {
+	if !runsCommand(os.Args[1:]) {
+		// Without subcommands cobra gives every word to the root command
+		rootCmd.RemoveCommand(rootCmd.Commands()...)
+	}
+	// A signal that stopped a server cleanly, or arrived after the work was
+	// done, is not a cancellation
+	err := rootCmd.ExecuteContext(ctx)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Cancelled")
+		os.Exit(exitInterrupt)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(exitError)
 	}
 }
 
+// terminalChildren counts the running child processes, such as the editor
+// and the pager, that own the terminal.
+var terminalChildren atomic.Int32
+
+// handleSignals calls cancel on the first signal and exit on the second.
+// Ctrl-C is ignored while a child owns the terminal: the terminal sends it
+// to the child too, and the child decides what it means, as with git.
+func handleSignals(signals <-chan os.Signal, cancel, exit func()) {
+	received := 0
+	for sig := range signals {
+		if sig == os.Interrupt && terminalChildren.Load() > 0 {
+			continue
+		}
+		received++
+		if received == 1 {
+			cancel()
+		} else {
+			exit()
+		}
+	}
+}
+
+// foregroundChild marks a child process as owning the terminal until the
+// returned function is called. Meanwhile Ctrl-C and Ctrl-\ are left to the
+// child, so kagi neither cancels nor exits on them.
+func foregroundChild() (done func()) {
+	terminalChildren.Add(1)
+	// Catching SIGQUIT stops the runtime from exiting on it; the signal is
+	// not ignored, as an ignored signal would stay ignored in the child
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+	return func() {
+		signal.Stop(quit)
+		terminalChildren.Add(-1)
+	}
+}
+
+// runsCommand reports whether args run a subcommand rather than ask a
+// question. Root flags are not inherited by subcommands, so a command name
+// must come first. Queries can start with one too, as in "kagi schema design
+// tips", so the rest of the line must also be valid for the command.
+func runsCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if args[0] == cobra.ShellCompRequestCmd || args[0] == cobra.ShellCompNoDescRequestCmd {
+		return true
+	}
+
+	rootCmd.InitDefaultHelpCmd()
+	if first, _, err := rootCmd.Find(args[:1]); err != nil || first == rootCmd {
+		return false
+	}
+	cmd, rest, err := rootCmd.Find(args)
+	if err != nil {
+		return false
+	}
+	positional, err := positionalArgs(cmd, rest)
+	if err != nil {
+		// A flag missing its value is for the command to report
+		return true
+	}
+	return validCommandArgs(cmd, positional)
+}
+
+// validCommandArgs reports whether args are valid positional arguments for
+// the subcommand cmd.
+func validCommandArgs(cmd *cobra.Command, args []string) bool {
+	if cmd.Name() == "help" {
+		// help takes a command path, e.g. kagi help prompts show
+		found, rest, err := rootCmd.Find(args)
+		return err == nil && len(rest) == 0 && (found != rootCmd || len(args) == 0)
+	}
+	return cmd.ValidateArgs(args) == nil
+}
+
+// positionalArgs returns the arguments in args that are not flags of cmd.
+// pflag parses them against copies of cmd's flags, so it knows which flags
+// take a value without setting any of them.
+func positionalArgs(cmd *cobra.Command, args []string) ([]string, error) {
+	cmd.InitDefaultHelpFlag()
+	flags := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	// Root flags such as -q may follow a query that starts with a command name
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	copyFlag := func(flag *pflag.Flag) {
+		if flags.Lookup(flag.Name) == nil {
+			copied := *flag
+			copied.Value = discardValue{flag.Value.Type()}
+			flags.AddFlag(&copied)
+		}
+	}
+	cmd.Flags().VisitAll(copyFlag)
+	cmd.InheritedFlags().VisitAll(copyFlag)
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Args(), nil
+}
+
+// discardValue accepts any flag value and keeps none of them.
+type discardValue struct{ typ string }
+
+func (v discardValue) Set(string) error { return nil }
+func (v discardValue) String() string   { return "" }
+func (v discardValue) Type() string     { return v.typ }
+
 func runCobra(cmd *cobra.Command, args []string) error {
 	if flagVersion {
 		if flagQuiet {
@@ -213,11 +502,40 @@ func runCobra(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(os.Stderr, "Debug: Timeout: %d\n", config.Timeout)
 	}
 
+	if config.Verbose {
+		for _, file := range config.Files {
+			if file.Truncated {
+				fmt.Fprintf(os.Stderr, "Attached %s (%d characters, truncated)\n", file.Path, file.Chars)
+			} else {
+				fmt.Fprintf(os.Stderr, "Attached %s (%d characters)\n", file.Path, file.Chars)
+			}
+		}
+	}
+
+	if config.DryRun != "" {
+		return writeDryRun(os.Stdout, config)
+	}
+
 	if config.Verbose || config.Debug {
 		fmt.Fprintf(os.Stderr, "Querying Kagi FastGPT API...\n")
 	}
 
-	resp, err := queryKagi(config.APIKey, config.Query, config.Timeout)
+	ctx := cmd.Context()
+	var trace *requestTrace
+	if config.Debug || config.TraceFile != "" {
+		trace = &requestTrace{}
+		ctx = withRequestTrace(ctx, trace)
+	}
+
+	resp, err := queryKagi(ctx, config.APIKey, config.Query, config.Timeout)
+	if config.Debug {
+		trace.writeDebug(os.Stderr)
+	}
+	if config.TraceFile != "" {
+		if traceErr := trace.writeTraceFile(config.TraceFile); traceErr != nil && err == nil {
+			err = traceErr
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -226,44 +544,187 @@ func runCobra(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(os.Stderr, "Response received (%dms)\n", resp.Meta.MS)
 	}
 
-	output, err := formatOutput(resp, config)
+	if config.Debug && len(resp.UnknownFields) > 0 {
+		fmt.Fprintf(os.Stderr, "Debug: Warning: API response contains fields this version does not handle (schema drift): %s\n", strings.Join(resp.UnknownFields, ", "))
+		fmt.Fprintf(os.Stderr, "Debug: Use --raw to see the complete response\n")
+	}
+
+	if config.OutputPath == "" {
+		output, err := renderOutput(resp, config)
+		if err != nil {
+			return err
+		}
+		return writeOutput(output, config)
+	}
+
+	// Files never contain terminal escape sequences
+	fileConfig := *config
+	fileConfig.Color = colorNever
+	fileConfig.Hyperlinks = hyperlinksNever
+
+	output, err := renderOutput(resp, &fileConfig)
 	if err != nil {
 		return err
 	}
-	fmt.Print(output)
+	if err := writeFileAtomic(cmd.Context(), config.OutputPath, output, config.Force); err != nil {
+		return err
+	}
+
+	if config.Verbose || flagOutput == outputAuto {
+		fmt.Fprintf(os.Stderr, "Saved to %s\n", config.OutputPath)
+	}
+
+	if config.Tee {
+		teeConfig := *config
+		teeConfig.Format = formatText
+		output, err := formatOutput(resp, &teeConfig)
+		if err != nil {
+			return err
+		}
+		return writeOutput(output, &teeConfig)
+	}
 
 	return nil
 }
 
+// renderOutput produces the output selected by the flags: the raw response,
+// a template, selected fields, or one of the output formats.
+func renderOutput(resp *FastGPTResponse, config *Config) (string, error) {
+	switch {
+	case config.Raw != "":
+		return formatRaw_output(resp, config)
+	case config.Template != nil:
+		return formatTemplate_output(resp, config)
+	case len(config.Fields) > 0:
+		return formatFields_output(resp, config)
+	default:
+		return formatOutput(resp, config)
+	}
+}
+
 func loadConfig(cmd *cobra.Command, args []string) (*Config, error) {
-	// Get API key (flag takes precedence over env var)
-	apiKey := flagAPIKey
-	if apiKey == "" {
-		apiKey = os.Getenv(envAPIKey)
+	// A dry run sends nothing, so it works without a key
+	dryRun := strings.ToLower(strings.TrimSpace(flagDryRun))
+	apiKey, err := resolveAPIKey()
+	if err != nil && dryRun == "" {
+		return nil, err
 	}
-	if apiKey == "" {
-		return nil, fmt.Errorf("no API key provided\nProvide via --api-key flag or KAGI_API_KEY environment variable")
+	if dryRun != "" && dryRun != dryRunJSON && dryRun != dryRunHTTP && dryRun != dryRunCurl {
+		return nil, fmt.Errorf("invalid value %q for --dry-run\nValid values: json, http, curl", flagDryRun)
 	}
 
-	query, err := getQuery(args)
+	question, input, mode, err := readQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	var query string
+	if flagEditor {
+		// The editor composes the question, starting from the one in args
+		// or stdin; stdin context is added after editing
+		if question, input, err = splitStdin(question, input, mode); err != nil {
+			return nil, err
+		}
+		if input != "" {
+			if _, err := parseStdinTemplate(flagStdinTemplate); err != nil {
+				return nil, err
+			}
+		}
+	} else if query, question, err = composeQuery(question, input, mode, flagStdinTemplate); err != nil {
+		return nil, err
+	}
+	files, err := loadAttachments(flagFiles, flagFileBudget)
 	if err != nil {
 		return nil, err
 	}
 
+	// An explicit --format wins over the output file extension
 	format := normalizeFormat(flagFormat)
+	if flagOutput != "" && flagOutput != outputAuto && !cmd.Flags().Changed("format") {
+		if inferred := formatForPath(flagOutput); inferred != "" {
+			format = inferred
+		}
+	}
 	if !isValidFormat(format) {
-		return nil, fmt.Errorf("invalid value %q for --format\nValid formats: text, txt, md, markdown, json", flagFormat)
+		return nil, fmt.Errorf("invalid value %q for --format\nValid formats: text, txt, md, markdown, json, jsonl, html, org, adoc, asciidoc, rst,\nslack, slack-blocks, blockkit, discord, telegram", flagFormat)
 	}
 
 	if flagTimeout <= 0 {
 		return nil, fmt.Errorf("invalid timeout value %q\nTimeout must be a positive integer (seconds)", fmt.Sprint(flagTimeout))
 	}
 
+	if err := configureTransport(); err != nil {
+		return nil, err
+	}
+
 	color := strings.ToLower(strings.TrimSpace(flagColor))
 	if color != colorAuto && color != colorAlways && color != colorNever {
 		return nil, fmt.Errorf("invalid value %q for --color\nValid values: auto, always, never", flagColor)
 	}
 
+	raw := strings.ToLower(strings.TrimSpace(flagRaw))
+	if raw != "" && raw != rawExact && raw != rawPretty {
+		return nil, fmt.Errorf("invalid value %q for --raw\nValid values: exact, pretty", flagRaw)
+	}
+
+	var tmpl *template.Template
+	if flagTemplate != "" || flagTemplateFile != "" {
+		if raw != "" {
+			return nil, fmt.Errorf("--raw cannot be used with --template or --template-file")
+		}
+		tmpl, err = loadTemplate(flagTemplate, flagTemplateFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(flagFields) > 0 {
+		if raw != "" || tmpl != nil {
+			return nil, fmt.Errorf("--field cannot be used with --raw, --template or --template-file")
+		}
+		for _, field := range flagFields {
+			if _, err := parseFieldPath(field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Fail before spending API credit on an answer that cannot be saved
+	if flagOutput != "" && flagOutput != outputAuto {
+		if err := checkOverwrite(flagOutput, flagForce); err != nil {
+			return nil, err
+		}
+	} else if flagOutput == "" && flagTee {
+		return nil, fmt.Errorf("--tee requires --output")
+	}
+
+	hyperlinks := strings.ToLower(strings.TrimSpace(flagHyperlinks))
+	if hyperlinks != hyperlinksAuto && hyperlinks != hyperlinksAlways && hyperlinks != hyperlinksNever {
+		return nil, fmt.Errorf("invalid value %q for --hyperlinks\nValid values: auto, always, never", flagHyperlinks)
+	}
+
+	// The editor opens last, so that mistakes in the flags are reported
+	// before the query is written
+	if flagEditor {
+		edited, err := editQuery(resolveEditor(), question)
+		if err != nil {
+			return nil, err
+		}
+		if query, question, err = composeQuery(edited, input, stdinAuto, flagStdinTemplate); err != nil {
+			return nil, err
+		}
+	}
+	if len(files) > 0 {
+		query = formatAttachments(files) + "\n\n" + query
+	}
+
+	outputPath := flagOutput
+	if outputPath == outputAuto {
+		outputPath = slugify(question) + extensionForFormat(format)
+		if err := checkOverwrite(outputPath, flagForce); err != nil {
+			return nil, err
+		}
+	}
+
 	// Debug implies verbose
 	verbose := flagVerbose
 	if flagDebug {
@@ -271,41 +732,74 @@ func loadConfig(cmd *cobra.Command, args []string) (*Config, error) {
 	}
 
 	return &Config{
-		APIKey:  apiKey,
-		Query:   query,
-		Format:  format,
-		Timeout: flagTimeout,
-		Heading: flagHeading,
-		Quiet:   flagQuiet,
-		Color:   color,
-		Verbose: verbose,
-		Debug:   flagDebug,
+		APIKey:     apiKey,
+		Question:   question,
+		Query:      query,
+		Files:      files,
+		Format:     format,
+		Timeout:    flagTimeout,
+		Heading:    flagHeading,
+		Quiet:      flagQuiet,
+		Standalone: flagStandalone,
+		Print0:     flagPrint0,
+		Raw:        raw,
+		Template:   tmpl,
+		Fields:     flagFields,
+		OutputPath: outputPath,
+		Force:      flagForce,
+		Tee:        flagTee,
+		Color:      color,
+		Hyperlinks: hyperlinks,
+		NoPager:    flagNoPager,
+		TraceFile:  flagTraceFile,
+		DryRun:     dryRun,
+		ShowKey:    flagShowKey,
+		Verbose:    verbose,
+		Debug:      flagDebug,
 	}, nil
 }
 
-// getQuery extracts the query from args or stdin
-func getQuery(args []string) (string, error) {
-	// First, try to get query from args
-	if len(args) > 0 {
-		query := strings.TrimSpace(strings.Join(args, " "))
-		if query != "" {
-			return query, nil
-		}
+// resolveAPIKey returns the API key; the flag takes precedence over the
+// environment variable. Replaying fixtures needs no key.
+func resolveAPIKey() (string, error) {
+	apiKey := flagAPIKey
+	if apiKey == "" {
+		apiKey = os.Getenv(envAPIKey)
+	}
+	if apiKey == "" && flagReplay != "" {
+		return replayAPIKey, nil
+	}
+	if apiKey == "" {
+		return "", fmt.Errorf("no API key provided\nProvide via --api-key flag or KAGI_API_KEY environment variable")
+	}
+	return apiKey, nil
+}
+
+// readQuery returns the question from args or the --prompt template, the
+// input read from stdin, and the --stdin mode.
+func readQuery(args []string) (question, input, mode string, err error) {
+	mode = strings.ToLower(strings.TrimSpace(flagStdin))
+	if mode != stdinAuto && mode != stdinContext && mode != stdinQuery && mode != stdinIgnore {
+		return "", "", "", fmt.Errorf("invalid value %q for --stdin\nValid values: auto, context, query, ignore", flagStdin)
 	}
 
-	// If no args, try stdin (only if not a terminal)
-	if !term.IsTerminal(int(os.Stdin.Fd())) {
+	question = strings.TrimSpace(strings.Join(args, " "))
+	if flagPrompt != "" {
+		if question, err = promptQuery(flagPrompt, args); err != nil {
+			return "", "", "", err
+		}
+	}
+	if shouldReadStdin(mode, question != "") {
 		stdinBytes, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			return "", fmt.Errorf("failed to read from stdin: %w", err)
+			return "", "", "", fmt.Errorf("failed to read from stdin: %w", err)
 		}
-		query := strings.TrimSpace(string(stdinBytes))
-		if query != "" {
-			return query, nil
+		if len(stdinBytes) > largeStdinBytes {
+			fmt.Fprintf(os.Stderr, "Warning: stdin is %d KB; large inputs may exceed the API's limits and use many tokens\n", len(stdinBytes)>>10)
 		}
+		input = strings.TrimSpace(string(stdinBytes))
 	}
-
-	return "", fmt.Errorf("no query provided\nUsage: kagi [flags] <query...>")
+	return question, input, mode, nil
 }
 
 func normalizeFormat(format string) string {
@@ -316,13 +810,23 @@ func normalizeFormat(format string) string {
 		return formatText
 	case "markdown":
 		return formatMarkdown
+	case "asciidoc":
+		return formatAsciiDoc
+	case "blockkit":
+		return formatSlackBlocks
 	default:
 		return format
 	}
 }
 
 func isValidFormat(format string) bool {
-	return format == formatText || format == formatMarkdown || format == formatJSON
+	switch format {
+	case formatText, formatMarkdown, formatJSON, formatJSONL, formatHTML, formatOrg, formatAsciiDoc, formatRST,
+		formatSlack, formatSlackBlocks, formatDiscord, formatTelegram:
+		return true
+	default:
+		return false
+	}
 }
 
 // ANSI color codes
@@ -355,12 +859,74 @@ func colorize(text, colorCode string, useColor bool) string {
 	return colorCode + text + ansiReset
 }
 
+func shouldUseHyperlinks(config *Config) bool {
+	switch config.Hyperlinks {
+	case hyperlinksAlways:
+		return true
+	case hyperlinksNever:
+		return false
+	case hyperlinksAuto:
+		return term.IsTerminal(int(os.Stdout.Fd()))
+	default:
+		return false
+	}
+}
+
+// hyperlink wraps text in an OSC 8 escape sequence so supporting terminals
+// render it as a clickable link to url.
+func hyperlink(text, url string, useHyperlinks bool) string {
+	if !useHyperlinks || url == "" {
+		return text
+	}
+	// Control characters in an API-provided URL would terminate the sequence early
+	url = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url)
+	return "\033]8;;" + url + "\033\\" + text + "\033]8;;\033\\"
+}
+
+// citationPattern matches the citation markers FastGPT places in its output, e.g. 【1】
+var citationPattern = regexp.MustCompile(`【(\d+)】`)
+
+// linkCitations turns each citation marker into a hyperlink to its reference.
+func linkCitations(text string, refs []Reference, useHyperlinks bool) string {
+	if !useHyperlinks || len(refs) == 0 {
+		return text
+	}
+	return citationPattern.ReplaceAllStringFunc(text, func(marker string) string {
+		n, err := strconv.Atoi(citationPattern.FindStringSubmatch(marker)[1])
+		if err != nil || n < 1 || n > len(refs) {
+			return marker
+		}
+		return hyperlink(marker, refs[n-1].URL, true)
+	})
+}
+
 func formatOutput(resp *FastGPTResponse, config *Config) (string, error) {
 	switch config.Format {
-	case formatJSON:
+	case formatJSON, formatJSONL:
 		return formatJSON_output(resp, config)
 	case formatMarkdown:
 		return formatMarkdown_output(resp, config), nil
+	case formatHTML:
+		return formatHTML_output(resp, config), nil
+	case formatOrg:
+		return formatOrg_output(resp, config), nil
+	case formatAsciiDoc:
+		return formatAsciiDoc_output(resp, config), nil
+	case formatRST:
+		return formatRST_output(resp, config), nil
+	case formatSlack:
+		return formatSlack_output(resp, config), nil
+	case formatSlackBlocks:
+		return formatSlackBlocks_output(resp, config)
+	case formatDiscord:
+		return formatDiscord_output(resp, config), nil
+	case formatTelegram:
+		return formatTelegram_output(resp, config), nil
 	default: // formatText
 		return formatText_output(resp, config), nil
 	}
@@ -369,14 +935,15 @@ func formatOutput(resp *FastGPTResponse, config *Config) (string, error) {
 func formatText_output(resp *FastGPTResponse, config *Config) string {
 	var output strings.Builder
 	useColor := shouldUseColor(config)
+	useHyperlinks := shouldUseHyperlinks(config)
 
 	if config.Heading && !config.Quiet {
-		heading := "# " + config.Query
+		heading := "# " + config.Question
 		output.WriteString(colorize(heading, ansiBoldBlue, useColor))
 		output.WriteString("\n\n")
 	}
 
-	output.WriteString(resp.Data.Output)
+	output.WriteString(linkCitations(resp.Data.Output, resp.Data.References, useHyperlinks))
 	output.WriteString("\n")
 
 	if !config.Quiet && len(resp.Data.References) > 0 {
@@ -388,10 +955,15 @@ func formatText_output(resp *FastGPTResponse, config *Config) string {
 			refNum := fmt.Sprintf("%d. ", i+1)
 			output.WriteString(colorize(refNum, ansiYellow, useColor))
 
-			output.WriteString(ref.Title)
-			output.WriteString(" - ")
+			if useHyperlinks {
+				// The title itself is the link, so the URL is not repeated
+				output.WriteString(hyperlink(ref.Title, ref.URL, true))
+			} else {
+				output.WriteString(ref.Title)
+				output.WriteString(" - ")
 
-			output.WriteString(colorize(ref.URL, ansiCyan, useColor))
+				output.WriteString(colorize(ref.URL, ansiCyan, useColor))
+			}
 
 			if ref.Snippet != "" {
 				output.WriteString(" - ")
@@ -416,7 +988,7 @@ func formatMarkdown_output(resp *FastGPTResponse, config *Config) string {
 
 	// Markdown always includes heading
 	output.WriteString("# ")
-	output.WriteString(config.Query)
+	output.WriteString(config.Question)
 	output.WriteString("\n\n")
 
 	output.WriteString(resp.Data.Output)
@@ -439,19 +1011,23 @@ func formatMarkdown_output(resp *FastGPTResponse, config *Config) string {
 	return output.String()
 }
 
+// formatJSON_output wraps the response in a versioned envelope (see schema.go).
+// The json format is pretty-printed; jsonl is compact, one object per line.
 func formatJSON_output(resp *FastGPTResponse, config *Config) (string, error) {
-	if config.Quiet {
-		jsonBytes, err := json.MarshalIndent(resp.Data.Output, "", "  ")
+	envelope := newJSONEnvelope(resp, config)
+
+	if config.Format == formatJSONL {
+		jsonBytes, err := json.Marshal(envelope)
 		if err != nil {
-			return "", fmt.Errorf("failed to marshal output to JSON: %w", err)
+			return "", fmt.Errorf("failed to marshal response to JSON: %w", err)
 		}
 		return string(jsonBytes) + "\n", nil
 	}
 
-	jsonBytes, err := json.MarshalIndent(resp, "", "  ")
+	jsonBytes, err := json.MarshalIndent(envelope, "", "  ")
 	if err != nil {
 		// Fallback to non-indented if pretty print fails
-		jsonBytes, err = json.Marshal(resp)
+		jsonBytes, err = json.Marshal(envelope)
 		if err != nil {
 			return "", fmt.Errorf("failed to marshal response to JSON: %w", err)
 		}
@@ -460,65 +1036,91 @@ func formatJSON_output(resp *FastGPTResponse, config *Config) (string, error) {
 	return string(jsonBytes) + "\n", nil
 }
 
-func queryKagi(apiKey, query string, timeout int) (*FastGPTResponse, error) {
+// newFastGPTRequest builds the API request for query, returning it with its
+// body. --dry-run prints the same request instead of sending it.
+func newFastGPTRequest(ctx context.Context, apiKey, query string) (*http.Request, []byte, error) {
 	reqBody := FastGPTRequest{
 		Query:     query,
 		WebSearch: webSearchEnabled,
 		Cache:     cacheEnabled,
 	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	// Keep <, > and & readable; piped context often contains them
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(reqBody); err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
+	jsonData := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiEndpoint, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", contentTypeJSON)
 	req.Header.Set("Authorization", authHeaderPrefix+apiKey)
+	return req, jsonData, nil
+}
+
+// queryKagi sends query to FastGPT. Cancelling ctx aborts the request.
+func queryKagi(ctx context.Context, apiKey, query string, timeout int) (result *FastGPTResponse, err error) {
+	// Record the outcome for the long-running commands' metrics, and the
+	// request phases when tracing
+	start := time.Now()
+	status := 0
+	outcome := outcomeNetworkError
+	trace := requestTraceFrom(ctx)
+	defer func() {
+		metrics.observeAPIRequest(outcome, status, time.Since(start), result)
+		trace.finish(result, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	req, jsonData, err := newFastGPTRequest(ctx, apiKey, query)
+	if err != nil {
+		return nil, err
+	}
+	req = trace.attach(req, len(jsonData))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			outcome = outcomeCancelled
+			return nil, fmt.Errorf("request cancelled: %w", context.Canceled)
+		}
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			outcome = outcomeTimeout
 			return nil, fmt.Errorf("request timeout exceeded (%ds)", timeout)
 		}
+		var missing *fixtureMissError
+		if errors.As(err, &missing) {
+			return nil, missing
+		}
 		return nil, fmt.Errorf("network request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
+	trace.response(resp, len(body))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var apiError FastGPTError
-		if json.Unmarshal(body, &apiError) == nil && len(apiError.Error) > 0 {
-			errMsg := apiError.Error[0].Msg
-			errCode := apiError.Error[0].Code
-
-			// Provide specific error messages for common status codes
-			switch resp.StatusCode {
-			case 401, 403:
-				return nil, fmt.Errorf("API request failed [%d]: Invalid API key", errCode)
-			case 429:
-				return nil, fmt.Errorf("API rate limit exceeded, try again later")
-			default:
-				return nil, fmt.Errorf("API request failed [%d]: %s", errCode, errMsg)
-			}
+		outcome = outcomeAPIError
+		return nil, &apiStatusError{
+			status:     resp.StatusCode,
+			retryAfter: resp.Header.Get("Retry-After"),
+			message:    apiErrorMessage(resp, body),
 		}
-
-		// Generic HTTP error if we can't parse the error response
-		return nil, fmt.Errorf("API returned HTTP %d: %s", resp.StatusCode, resp.Status)
 	}
 
+	outcome = outcomeInvalidResponse
 	var apiResp FastGPTResponse
 	if err := json.Unmarshal(body, &apiResp); err != nil {
 		return nil, fmt.Errorf("failed to parse API response: %w", err)
@@ -528,5 +1130,41 @@ func queryKagi(apiKey, query string, timeout int) (*FastGPTResponse, error) {
 		return nil, fmt.Errorf("API returned empty response")
 	}
 
+	apiResp.Raw = body
+	apiResp.UnknownFields = unknownFields(body, reflect.TypeOf(apiResp))
+	outcome = outcomeSuccess
+
 	return &apiResp, nil
 }
+
+// apiStatusError is an error response from the API. It keeps the HTTP status
+// and Retry-After header for kagi serve to pass on.
+type apiStatusError struct {
+	status     int
+	retryAfter string
+	message    string
+}
+
+func (e *apiStatusError) Error() string { return e.message }
+
+// apiErrorMessage describes an error response from the API.
+func apiErrorMessage(resp *http.Response, body []byte) string {
+	var apiError FastGPTError
+	if json.Unmarshal(body, &apiError) == nil && len(apiError.Error) > 0 {
+		errMsg := apiError.Error[0].Msg
+		errCode := apiError.Error[0].Code
+
+		// Provide specific error messages for common status codes
+		switch resp.StatusCode {
+		case 401, 403:
+			return fmt.Sprintf("API request failed [%d]: Invalid API key", errCode)
+		case 429:
+			return "API rate limit exceeded, try again later"
+		default:
+			return fmt.Sprintf("API request failed [%d]: %s", errCode, errMsg)
+		}
+	}
+
+	// Generic HTTP error if we can't parse the error response
+	return fmt.Sprintf("API returned HTTP %d: %s", resp.StatusCode, resp.Status)
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/spf13/cobra"
 )
 
 func TestNormalizeFormat(t *testing.T) {
@@ -18,6 +20,8 @@ func TestNormalizeFormat(t *testing.T) {
 		{"text format unchanged", "text", "text"},
 		{"txt alias to text", "txt", "text"},
 		{"markdown alias to md", "markdown", "md"},
+		{"asciidoc alias to adoc", "asciidoc", "adoc"},
+		{"blockkit alias to slack-blocks", "blockkit", "slack-blocks"},
 		{"md format unchanged", "md", "md"},
 		{"json format unchanged", "json", "json"},
 		{"uppercase text", "TEXT", "text"},
@@ -48,6 +52,15 @@ func TestIsValidFormat(t *testing.T) {
 		{"text is valid", "text", true},
 		{"md is valid", "md", true},
 		{"json is valid", "json", true},
+		{"html is valid", "html", true},
+		{"org is valid", "org", true},
+		{"adoc is valid", "adoc", true},
+		{"rst is valid", "rst", true},
+		{"asciidoc is invalid (not normalized)", "asciidoc", false},
+		{"slack is valid", "slack", true},
+		{"slack-blocks is valid", "slack-blocks", true},
+		{"discord is valid", "discord", true},
+		{"telegram is valid", "telegram", true},
 		{"txt is invalid (not normalized)", "txt", false},
 		{"markdown is invalid (not normalized)", "markdown", false},
 		{"empty is invalid", "", false},
@@ -153,6 +166,108 @@ func TestShouldUseColor(t *testing.T) {
 	})
 }
 
+func TestShouldUseHyperlinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected bool
+	}{
+		{"always returns true", hyperlinksAlways, true},
+		{"never returns false", hyperlinksNever, false},
+		{"auto in non-TTY returns false", hyperlinksAuto, false},
+		{"invalid mode returns false", "invalid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Hyperlinks: tt.mode}
+			result := shouldUseHyperlinks(config)
+			if result != tt.expected {
+				t.Errorf("shouldUseHyperlinks(config with Hyperlinks=%q) = %v; want %v", tt.mode, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHyperlink(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		url           string
+		useHyperlinks bool
+		expected      string
+	}{
+		{
+			name:          "hyperlinks enabled",
+			text:          "Title",
+			url:           "https://example.com",
+			useHyperlinks: true,
+			expected:      "\033]8;;https://example.com\033\\Title\033]8;;\033\\",
+		},
+		{
+			name:          "hyperlinks disabled returns plain text",
+			text:          "Title",
+			url:           "https://example.com",
+			useHyperlinks: false,
+			expected:      "Title",
+		},
+		{
+			name:          "empty URL returns plain text",
+			text:          "Title",
+			url:           "",
+			useHyperlinks: true,
+			expected:      "Title",
+		},
+		{
+			name:          "control characters stripped from URL",
+			text:          "Title",
+			url:           "https://example.com/\033\\evil\a",
+			useHyperlinks: true,
+			expected:      "\033]8;;https://example.com/\\evil\033\\Title\033]8;;\033\\",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := hyperlink(tt.text, tt.url, tt.useHyperlinks)
+			if result != tt.expected {
+				t.Errorf("hyperlink(%q, %q, %v) = %q; want %q", tt.text, tt.url, tt.useHyperlinks, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLinkCitations(t *testing.T) {
+	refs := []Reference{
+		{Title: "One", URL: "https://example.com/1"},
+		{Title: "Two", URL: "https://example.com/2"},
+	}
+
+	t.Run("markers linked to references", func(t *testing.T) {
+		result := linkCitations("Fact 【1】 and fact 【2】.", refs, true)
+		if !strings.Contains(result, "\033]8;;https://example.com/1\033\\【1】") {
+			t.Errorf("First citation not linked: %q", result)
+		}
+		if !strings.Contains(result, "\033]8;;https://example.com/2\033\\【2】") {
+			t.Errorf("Second citation not linked: %q", result)
+		}
+	})
+
+	t.Run("out of range marker left unchanged", func(t *testing.T) {
+		result := linkCitations("Fact 【3】.", refs, true)
+		if result != "Fact 【3】." {
+			t.Errorf("linkCitations() = %q; want unchanged", result)
+		}
+	})
+
+	t.Run("disabled returns text unchanged", func(t *testing.T) {
+		result := linkCitations("Fact 【1】.", refs, false)
+		if result != "Fact 【1】." {
+			t.Errorf("linkCitations() = %q; want unchanged", result)
+		}
+	})
+}
+
 func createTestResponse() *FastGPTResponse {
 	return &FastGPTResponse{
 		Meta: struct {
@@ -192,11 +307,11 @@ func TestFormatText_output(t *testing.T) {
 
 	t.Run("basic text output without heading", func(t *testing.T) {
 		config := &Config{
-			Query:   "test query",
-			Format:  formatText,
-			Heading: false,
-			Quiet:   false,
-			Color:   colorNever,
+			Question: "test query",
+			Format:   formatText,
+			Heading:  false,
+			Quiet:    false,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -222,11 +337,11 @@ func TestFormatText_output(t *testing.T) {
 
 	t.Run("text output with heading", func(t *testing.T) {
 		config := &Config{
-			Query:   "test query",
-			Format:  formatText,
-			Heading: true,
-			Quiet:   false,
-			Color:   colorNever,
+			Question: "test query",
+			Format:   formatText,
+			Heading:  true,
+			Quiet:    false,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -245,11 +360,11 @@ func TestFormatText_output(t *testing.T) {
 
 	t.Run("text output in quiet mode", func(t *testing.T) {
 		config := &Config{
-			Query:   "test query",
-			Format:  formatText,
-			Heading: false,
-			Quiet:   true,
-			Color:   colorNever,
+			Question: "test query",
+			Format:   formatText,
+			Heading:  false,
+			Quiet:    true,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -268,11 +383,11 @@ func TestFormatText_output(t *testing.T) {
 
 	t.Run("text output with colors enabled", func(t *testing.T) {
 		config := &Config{
-			Query:   "test query",
-			Format:  formatText,
-			Heading: true,
-			Quiet:   false,
-			Color:   colorAlways,
+			Question: "test query",
+			Format:   formatText,
+			Heading:  true,
+			Quiet:    false,
+			Color:    colorAlways,
 		}
 
 		result := formatText_output(resp, config)
@@ -286,16 +401,34 @@ func TestFormatText_output(t *testing.T) {
 		}
 	})
 
+	t.Run("text output with hyperlinks enabled", func(t *testing.T) {
+		config := &Config{
+			Question:   "test query",
+			Format:     formatText,
+			Color:      colorNever,
+			Hyperlinks: hyperlinksAlways,
+		}
+
+		result := formatText_output(resp, config)
+
+		if !strings.Contains(result, "\033]8;;https://example.com/1\033\\Test Reference 1\033]8;;\033\\") {
+			t.Errorf("Reference title should be an OSC 8 hyperlink")
+		}
+		if strings.Contains(result, " - https://example.com/1") {
+			t.Errorf("Reference URL should not be repeated when hyperlinks are enabled")
+		}
+	})
+
 	t.Run("text output with empty references", func(t *testing.T) {
 		respNoRefs := createTestResponse()
 		respNoRefs.Data.References = []Reference{}
 
 		config := &Config{
-			Query:   "test query",
-			Format:  formatText,
-			Heading: false,
-			Quiet:   false,
-			Color:   colorNever,
+			Question: "test query",
+			Format:   formatText,
+			Heading:  false,
+			Quiet:    false,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(respNoRefs, config)
@@ -315,9 +448,9 @@ func TestFormatMarkdown_output(t *testing.T) {
 
 	t.Run("basic markdown output", func(t *testing.T) {
 		config := &Config{
-			Query:  "test query",
-			Format: formatMarkdown,
-			Quiet:  false,
+			Question: "test query",
+			Format:   formatMarkdown,
+			Quiet:    false,
 		}
 
 		result := formatMarkdown_output(resp, config)
@@ -347,9 +480,9 @@ func TestFormatMarkdown_output(t *testing.T) {
 
 	t.Run("markdown output in quiet mode", func(t *testing.T) {
 		config := &Config{
-			Query:  "test query",
-			Format: formatMarkdown,
-			Quiet:  true,
+			Question: "test query",
+			Format:   formatMarkdown,
+			Quiet:    true,
 		}
 
 		result := formatMarkdown_output(resp, config)
@@ -371,9 +504,9 @@ func TestFormatMarkdown_output(t *testing.T) {
 		respNoRefs.Data.References = []Reference{}
 
 		config := &Config{
-			Query:  "test query",
-			Format: formatMarkdown,
-			Quiet:  false,
+			Question: "test query",
+			Format:   formatMarkdown,
+			Quiet:    false,
 		}
 
 		result := formatMarkdown_output(respNoRefs, config)
@@ -396,9 +529,9 @@ func TestFormatJSON_output(t *testing.T) {
 
 	t.Run("full JSON output", func(t *testing.T) {
 		config := &Config{
-			Query:  "test query",
-			Format: formatJSON,
-			Quiet:  false,
+			Question: "test query",
+			Format:   formatJSON,
+			Quiet:    false,
 		}
 
 		result, err := formatJSON_output(resp, config)
@@ -406,16 +539,22 @@ func TestFormatJSON_output(t *testing.T) {
 			t.Fatalf("formatJSON_output failed: %v", err)
 		}
 
-		var parsed map[string]interface{}
+		var parsed struct {
+			SchemaVersion string                 `json:"schema_version"`
+			Response      map[string]interface{} `json:"response"`
+		}
 		if err := json.Unmarshal([]byte(result), &parsed); err != nil {
 			t.Errorf("Output is not valid JSON: %v", err)
 		}
 
-		if _, ok := parsed["meta"]; !ok {
-			t.Errorf("JSON output missing 'meta' field")
+		if parsed.SchemaVersion != jsonSchemaVersion {
+			t.Errorf("JSON output schema_version = %q; want %q", parsed.SchemaVersion, jsonSchemaVersion)
 		}
-		if _, ok := parsed["data"]; !ok {
-			t.Errorf("JSON output missing 'data' field")
+		if _, ok := parsed.Response["meta"]; !ok {
+			t.Errorf("JSON output missing 'response.meta' field")
+		}
+		if _, ok := parsed.Response["data"]; !ok {
+			t.Errorf("JSON output missing 'response.data' field")
 		}
 
 		if !strings.Contains(result, "This is a test response") {
@@ -430,9 +569,9 @@ func TestFormatJSON_output(t *testing.T) {
 
 	t.Run("JSON output in quiet mode", func(t *testing.T) {
 		config := &Config{
-			Query:  "test query",
-			Format: formatJSON,
-			Quiet:  true,
+			Question: "test query",
+			Format:   formatJSON,
+			Quiet:    true,
 		}
 
 		result, err := formatJSON_output(resp, config)
@@ -440,13 +579,19 @@ func TestFormatJSON_output(t *testing.T) {
 			t.Fatalf("formatJSON_output failed: %v", err)
 		}
 
-		var parsed string
+		var parsed struct {
+			Response struct {
+				Data struct {
+					Output string `json:"output"`
+				} `json:"data"`
+			} `json:"response"`
+		}
 		if err := json.Unmarshal([]byte(strings.TrimSpace(result)), &parsed); err != nil {
 			t.Errorf("Quiet JSON output is not valid JSON: %v", err)
 		}
 
-		if parsed != "This is a test response" {
-			t.Errorf("Quiet JSON output = %q; want %q", parsed, "This is a test response")
+		if parsed.Response.Data.Output != "This is a test response" {
+			t.Errorf("Quiet JSON output = %q; want %q", parsed.Response.Data.Output, "This is a test response")
 		}
 
 		if strings.Contains(result, "meta") {
@@ -482,15 +627,35 @@ func TestFormatOutput(t *testing.T) {
 			format:        formatJSON,
 			shouldContain: `"output"`,
 		},
+		{
+			name:          "html format dispatches to html formatter",
+			format:        formatHTML,
+			shouldContain: "<h1>test query</h1>",
+		},
+		{
+			name:          "org format dispatches to org formatter",
+			format:        formatOrg,
+			shouldContain: "* test query",
+		},
+		{
+			name:          "adoc format dispatches to asciidoc formatter",
+			format:        formatAsciiDoc,
+			shouldContain: "= test query",
+		},
+		{
+			name:          "rst format dispatches to rst formatter",
+			format:        formatRST,
+			shouldContain: "test query\n==========",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			config := &Config{
-				Query:  "test query",
-				Format: tt.format,
-				Quiet:  false,
-				Color:  colorNever,
+				Question: "test query",
+				Format:   tt.format,
+				Quiet:    false,
+				Color:    colorNever,
 			}
 
 			result, err := formatOutput(resp, config)
@@ -508,47 +673,50 @@ func TestFormatOutput(t *testing.T) {
 	}
 }
 
-func TestGetQuery(t *testing.T) {
+func TestReadQuery(t *testing.T) {
 	t.Run("single arg query", func(t *testing.T) {
 		args := []string{"test"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery returned error: %v", err)
+			t.Errorf("readQuery returned error: %v", err)
 		}
 		if result != "test" {
-			t.Errorf("getQuery(%v) = %q; want %q", args, result, "test")
+			t.Errorf("readQuery(%v) = %q; want %q", args, result, "test")
 		}
 	})
 
 	t.Run("multiple args concatenated", func(t *testing.T) {
 		args := []string{"golang", "best", "practices"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery returned error: %v", err)
+			t.Errorf("readQuery returned error: %v", err)
 		}
 		expected := "golang best practices"
 		if result != expected {
-			t.Errorf("getQuery(%v) = %q; want %q", args, result, expected)
+			t.Errorf("readQuery(%v) = %q; want %q", args, result, expected)
 		}
 	})
 
 	t.Run("args with extra whitespace", func(t *testing.T) {
 		args := []string{"  test  ", "  query  "}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery returned error: %v", err)
+			t.Errorf("readQuery returned error: %v", err)
 		}
 		// Join preserves the spacing, but TrimSpace at the end should clean it
 		if !strings.Contains(result, "test") || !strings.Contains(result, "query") {
-			t.Errorf("getQuery(%v) = %q; should contain 'test' and 'query'", args, result)
+			t.Errorf("readQuery(%v) = %q; should contain 'test' and 'query'", args, result)
 		}
 	})
 
 	t.Run("empty args returns error", func(t *testing.T) {
 		args := []string{}
-		_, err := getQuery(args)
+		question, input, mode, err := readQuery(args)
 		if err == nil {
-			t.Errorf("getQuery(%v) should return error for empty args", args)
+			_, _, err = composeQuery(question, input, mode, defaultStdinTemplate)
+		}
+		if err == nil {
+			t.Errorf("readQuery(%v) should return error for empty args", args)
 		}
 		if !strings.Contains(err.Error(), "no query provided") {
 			t.Errorf("Error message should mention 'no query provided', got: %v", err)
@@ -557,9 +725,12 @@ func TestGetQuery(t *testing.T) {
 
 	t.Run("args with only whitespace returns error", func(t *testing.T) {
 		args := []string{"   ", "  "}
-		_, err := getQuery(args)
+		question, input, mode, err := readQuery(args)
 		if err == nil {
-			t.Errorf("getQuery(%v) should return error for whitespace-only args", args)
+			_, _, err = composeQuery(question, input, mode, defaultStdinTemplate)
+		}
+		if err == nil {
+			t.Errorf("readQuery(%v) should return error for whitespace-only args", args)
 		}
 		if !strings.Contains(err.Error(), "no query provided") {
 			t.Errorf("Error message should mention 'no query provided', got: %v", err)
@@ -568,17 +739,95 @@ func TestGetQuery(t *testing.T) {
 
 	t.Run("unicode and special characters", func(t *testing.T) {
 		args := []string{"测试", "🚀", "query"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery returned error: %v", err)
+			t.Errorf("readQuery returned error: %v", err)
 		}
 		expected := "测试 🚀 query"
 		if result != expected {
-			t.Errorf("getQuery(%v) = %q; want %q", args, result, expected)
+			t.Errorf("readQuery(%v) = %q; want %q", args, result, expected)
 		}
 	})
 }
 
+func TestRunsCommand_QueriesStartingWithCommandNames(t *testing.T) {
+	rootCmd.InitDefaultHelpCmd()
+	for _, cmd := range rootCmd.Commands() {
+		name := cmd.Name()
+		t.Run(name, func(t *testing.T) {
+			for _, args := range [][]string{
+				{name, "design", "tips"},
+				{name, "design", "tips", "-q"},
+				{"-q", name},
+			} {
+				if runsCommand(args) {
+					t.Errorf("runsCommand(%q) = true; want a query", args)
+				}
+			}
+		})
+	}
+}
+
+func TestRunsCommand_Commands(t *testing.T) {
+	tests := [][]string{
+		{"schema"},
+		{"completion", "bash"},
+		{"serve", "--listen", "127.0.0.1:0", "--token", "secret"},
+		{"serve", "--listen=unix:/tmp/kagi.sock"},
+		{"serve", "--bogus"},
+		{"mock-server", "--fail", "rate-limit"},
+		{"mcp"},
+		{"mcp", "--help"},
+		{"prompts"},
+		{"prompts", "show", "compare"},
+		{"prompts", "show", "--help", "compare"},
+		{"help", "prompts", "show"},
+		{"help"},
+		{cobra.ShellCompRequestCmd, "schema", ""},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			if !runsCommand(args) {
+				t.Errorf("runsCommand(%q) = false; want the command", args)
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {"-f", "json", "golang", "tips"}, {"golang"}} {
+		if runsCommand(args) {
+			t.Errorf("runsCommand(%q) = true; want a query", args)
+		}
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	serve, _, _ := rootCmd.Find([]string{"serve"})
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--listen", "127.0.0.1:0", "static", "files"}, "static files"},
+		{[]string{"--listen=127.0.0.1:0", "static"}, "static"},
+		{[]string{"--verbose", "static"}, "static"},
+		{[]string{"static", "--", "--listen"}, "static --listen"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got, err := positionalArgs(serve, tt.args)
+			if err != nil {
+				t.Fatalf("positionalArgs() error: %v", err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("positionalArgs() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	if flagListen != defaultListenAddr {
+		t.Errorf("positionalArgs set --listen to %q", flagListen)
+	}
+}
+
 func TestQueryKagi_ResponseParsing(t *testing.T) {
 	t.Run("successful API response", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -699,9 +948,9 @@ func TestEdgeCases(t *testing.T) {
 			longQuery[i] = "word"
 		}
 		args := longQuery
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery should handle long queries: %v", err)
+			t.Errorf("readQuery should handle long queries: %v", err)
 		}
 		if len(result) < 200 {
 			t.Errorf("Long query was truncated unexpectedly")
@@ -710,9 +959,9 @@ func TestEdgeCases(t *testing.T) {
 
 	t.Run("query with special characters", func(t *testing.T) {
 		args := []string{"query", "with", "special", "chars:", "<>&\"'"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery should handle special chars: %v", err)
+			t.Errorf("readQuery should handle special chars: %v", err)
 		}
 		if !strings.Contains(result, "<>&\"'") {
 			t.Errorf("Special characters should be preserved in query")
@@ -721,9 +970,9 @@ func TestEdgeCases(t *testing.T) {
 
 	t.Run("query with newlines and tabs", func(t *testing.T) {
 		args := []string{"query\nwith\nnewlines", "and\ttabs"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
-			t.Errorf("getQuery should handle newlines and tabs: %v", err)
+			t.Errorf("readQuery should handle newlines and tabs: %v", err)
 		}
 		if !strings.Contains(result, "query") {
 			t.Errorf("Query content should be preserved")
@@ -735,9 +984,9 @@ func TestEdgeCases(t *testing.T) {
 		resp.Data.References[0].Snippet = ""
 
 		config := &Config{
-			Query:  "test",
-			Format: formatText,
-			Color:  colorNever,
+			Question: "test",
+			Format:   formatText,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -758,9 +1007,9 @@ func TestEdgeCases(t *testing.T) {
 		}
 
 		config := &Config{
-			Query:  "test",
-			Format: formatText,
-			Color:  colorNever,
+			Question: "test",
+			Format:   formatText,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -797,9 +1046,9 @@ func TestEdgeCases(t *testing.T) {
 		resp.Data.References[0].Snippet = "这是一个中文摘要"
 
 		config := &Config{
-			Query:  "测试查询",
-			Format: formatText,
-			Color:  colorNever,
+			Question: "测试查询",
+			Format:   formatText,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -816,9 +1065,9 @@ func TestEdgeCases(t *testing.T) {
 		resp.Data.Output = "Unicode: 你好 🌍"
 
 		config := &Config{
-			Query:  "test",
-			Format: formatJSON,
-			Quiet:  false,
+			Question: "test",
+			Format:   formatJSON,
+			Quiet:    false,
 		}
 
 		result, err := formatJSON_output(resp, config)
@@ -839,9 +1088,9 @@ func TestEdgeCases(t *testing.T) {
 		resp.Data.References[0].URL = "https://example.com/path?param=value&other=test"
 
 		config := &Config{
-			Query:  "test",
-			Format: formatMarkdown,
-			Quiet:  false,
+			Question: "test",
+			Format:   formatMarkdown,
+			Quiet:    false,
 		}
 
 		result := formatMarkdown_output(resp, config)
@@ -865,7 +1114,7 @@ func TestEdgeCases(t *testing.T) {
 
 func TestErrorConditions(t *testing.T) {
 	t.Run("invalid format string", func(t *testing.T) {
-		invalidFormats := []string{"xml", "yaml", "html", "pdf", ""}
+		invalidFormats := []string{"xml", "yaml", "pdf", ""}
 		for _, format := range invalidFormats {
 			normalized := normalizeFormat(format)
 			if isValidFormat(normalized) {
@@ -888,9 +1137,9 @@ func TestErrorConditions(t *testing.T) {
 		}
 
 		config := &Config{
-			Query:  "test",
-			Format: formatText,
-			Color:  colorNever,
+			Question: "test",
+			Format:   formatText,
+			Color:    colorNever,
 		}
 
 		result := formatText_output(resp, config)
@@ -911,7 +1160,10 @@ func TestErrorConditions(t *testing.T) {
 
 	t.Run("empty query after trimming", func(t *testing.T) {
 		args := []string{"   "}
-		_, err := getQuery(args)
+		question, input, mode, err := readQuery(args)
+		if err == nil {
+			_, _, err = composeQuery(question, input, mode, defaultStdinTemplate)
+		}
 		if err == nil {
 			t.Errorf("Should return error for whitespace-only query")
 		}
@@ -919,7 +1171,7 @@ func TestErrorConditions(t *testing.T) {
 
 	t.Run("query with only special characters", func(t *testing.T) {
 		args := []string{"!@#$%^&*()"}
-		result, err := getQuery(args)
+		result, _, _, err := readQuery(args)
 		if err != nil {
 			t.Errorf("Should accept query with only special chars: %v", err)
 		}
@@ -948,7 +1200,7 @@ func TestConfigValidation(t *testing.T) {
 	})
 
 	t.Run("format validation", func(t *testing.T) {
-		validFormats := []string{formatText, formatMarkdown, formatJSON}
+		validFormats := []string{formatText, formatMarkdown, formatJSON, formatHTML, formatOrg, formatAsciiDoc, formatRST, formatSlack, formatSlackBlocks, formatDiscord, formatTelegram}
 		for _, format := range validFormats {
 			if !isValidFormat(format) {
 				t.Errorf("Format %q should be valid", format)
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"strings"
+)
+
+// FastGPT answers are written in a small subset of markdown. The parser in
+// this file understands just enough of it (headings, paragraphs, lists,
+// quotes, fenced code and simple inline spans) for the output formats that
+// need to convert the answer into another markup language.
+
+type mdBlockKind int
+
+const (
+	mdParagraph mdBlockKind = iota
+	mdHeading
+	mdCodeBlock
+	mdList
+	mdQuote
+	mdRule
+)
+
+// mdBlock is a single block-level element of the answer.
+type mdBlock struct {
+	Kind    mdBlockKind
+	Level   int      // Heading level (1-6)
+	Ordered bool     // Numbered list
+	Lang    string   // Code block info string
+	Text    string   // Inline text for paragraphs, headings and quotes; raw code for code blocks
+	Items   []string // Inline text for each list item
+}
+
+type mdInlineKind int
+
+const (
+	mdText mdInlineKind = iota
+	mdBold
+	mdItalic
+	mdCode
+	mdLink
+)
+
+// mdInline is a span of inline text. Spans are not nested.
+type mdInline struct {
+	Kind mdInlineKind
+	Text string
+	URL  string
+}
+
+// parseMarkdown splits markdown source into block-level elements.
+func parseMarkdown(src string) []mdBlock {
+	var blocks []mdBlock
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var para []string
+	flushPara := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, mdBlock{Kind: mdParagraph, Text: strings.Join(para, " ")})
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flushPara()
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flushPara()
+			fence := trimmed[:3]
+			lang := strings.TrimSpace(trimmed[3:])
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, mdBlock{Kind: mdCodeBlock, Lang: lang, Text: strings.Join(code, "\n")})
+
+		case headingLevel(trimmed) > 0:
+			flushPara()
+			level := headingLevel(trimmed)
+			text := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(trimmed[level:]), "#"))
+			blocks = append(blocks, mdBlock{Kind: mdHeading, Level: level, Text: text})
+
+		case isRule(trimmed):
+			flushPara()
+			blocks = append(blocks, mdBlock{Kind: mdRule})
+
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			var quote []string
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(t, ">") {
+					i--
+					break
+				}
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(t, ">")))
+			}
+			blocks = append(blocks, mdBlock{Kind: mdQuote, Text: strings.Join(quote, " ")})
+
+		case listItemText(trimmed) != "":
+			flushPara()
+			_, ordered := orderedItemText(trimmed)
+			block := mdBlock{Kind: mdList, Ordered: ordered}
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if t == "" {
+					// A blank line only continues the list if another item of the same kind follows
+					if i+1 < len(lines) && isListItem(strings.TrimSpace(lines[i+1]), ordered) {
+						continue
+					}
+					break
+				}
+				if isListItem(t, ordered) {
+					block.Items = append(block.Items, listItemText(t))
+				} else if listItemText(t) == "" && len(block.Items) > 0 && strings.HasPrefix(lines[i], " ") {
+					// Indented continuation of the previous item
+					block.Items[len(block.Items)-1] += " " + t
+				} else {
+					i--
+					break
+				}
+			}
+			blocks = append(blocks, block)
+
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+
+	return blocks
+}
+
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && level < 6 && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level >= len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+func isRule(line string) bool {
+	if len(line) < 3 {
+		return false
+	}
+	for _, marker := range []string{"-", "*", "_"} {
+		if strings.Trim(strings.ReplaceAll(line, " ", ""), marker) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// listItemText returns the text of a bullet or numbered list item, or "" if
+// the line is not a list item.
+func listItemText(line string) string {
+	for _, bullet := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(line, bullet) {
+			return strings.TrimSpace(line[len(bullet):])
+		}
+	}
+	text, _ := orderedItemText(line)
+	return text
+}
+
+// isListItem reports whether line is a list item of the given kind.
+func isListItem(line string, ordered bool) bool {
+	if listItemText(line) == "" {
+		return false
+	}
+	_, isOrdered := orderedItemText(line)
+	return isOrdered == ordered
+}
+
+func orderedItemText(line string) (string, bool) {
+	digits := 0
+	for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || digits+1 >= len(line) {
+		return "", false
+	}
+	if (line[digits] != '.' && line[digits] != ')') || line[digits+1] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(line[digits+2:]), true
+}
+
+// parseInline splits inline markdown into text, emphasis, code and link spans.
+func parseInline(src string) []mdInline {
+	var spans []mdInline
+	var text strings.Builder
+
+	flushText := func() {
+		if text.Len() > 0 {
+			spans = append(spans, mdInline{Kind: mdText, Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end >= 0 {
+				flushText()
+				spans = append(spans, mdInline{Kind: mdCode, Text: rest[1 : end+1]})
+				i += end + 2
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			delim := rest[:2]
+			if end := strings.Index(rest[2:], delim); end > 0 {
+				flushText()
+				spans = append(spans, mdInline{Kind: mdBold, Text: rest[2 : end+2]})
+				i += end + 4
+				continue
+			}
+
+		case rest[0] == '*' || rest[0] == '_':
+			delim := rest[:1]
+			// Underscores inside words (snake_case) are not emphasis
+			wordStart := i == 0 || !isWordByte(src[i-1])
+			if (delim == "*" || wordStart) && len(rest) > 1 && rest[1] != ' ' {
+				if end := strings.Index(rest[1:], delim); end > 0 {
+					after := i + end + 2
+					if delim == "*" || after >= len(src) || !isWordByte(src[after]) {
+						flushText()
+						spans = append(spans, mdInline{Kind: mdItalic, Text: rest[1 : end+1]})
+						i = after
+						continue
+					}
+				}
+			}
+
+		case rest[0] == '[':
+			if label, url, n := parseLink(rest); n > 0 {
+				flushText()
+				spans = append(spans, mdInline{Kind: mdLink, Text: label, URL: url})
+				i += n
+				continue
+			}
+		}
+
+		text.WriteByte(src[i])
+		i++
+	}
+	flushText()
+
+	return spans
+}
+
+// parseLink parses [label](url) at the start of s, returning the number of
+// bytes consumed or 0 if s does not start with a link.
+func parseLink(s string) (label, url string, n int) {
+	// The label ends at the ] matching the opening [, which must be
+	// followed by the URL, so "[a] and [b](c)" is not one link
+	closeLabel, depth := -1, 0
+	for i := 0; i < len(s) && closeLabel < 0; i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				closeLabel = i
+			}
+		}
+	}
+	if closeLabel < 0 || !strings.HasPrefix(s[closeLabel+1:], "(") {
+		return "", "", 0
+	}
+	closeURL := strings.IndexByte(s[closeLabel+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0
+	}
+	label = s[1:closeLabel]
+	url = strings.TrimSpace(s[closeLabel+2 : closeLabel+2+closeURL])
+	if strings.ContainsAny(url, " \n") {
+		return "", "", 0
+	}
+	return label, url, closeLabel + 3 + closeURL
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
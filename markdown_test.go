@@ -0,0 +1,152 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseMarkdown(t *testing.T) {
+	t.Run("paragraphs split on blank lines", func(t *testing.T) {
+		blocks := parseMarkdown("First line\ncontinues.\n\nSecond paragraph.")
+		if len(blocks) != 2 {
+			t.Fatalf("parseMarkdown() returned %d blocks; want 2", len(blocks))
+		}
+		if blocks[0].Kind != mdParagraph || blocks[0].Text != "First line continues." {
+			t.Errorf("First block = %+v; want joined paragraph", blocks[0])
+		}
+	})
+
+	t.Run("headings", func(t *testing.T) {
+		blocks := parseMarkdown("## Section ##\nBody")
+		if len(blocks) != 2 || blocks[0].Kind != mdHeading {
+			t.Fatalf("parseMarkdown() = %+v; want heading then paragraph", blocks)
+		}
+		if blocks[0].Level != 2 || blocks[0].Text != "Section" {
+			t.Errorf("Heading = %+v; want level 2 'Section'", blocks[0])
+		}
+	})
+
+	t.Run("hash without space is not a heading", func(t *testing.T) {
+		blocks := parseMarkdown("#hashtag")
+		if len(blocks) != 1 || blocks[0].Kind != mdParagraph {
+			t.Errorf("parseMarkdown(#hashtag) = %+v; want paragraph", blocks)
+		}
+	})
+
+	t.Run("fenced code block", func(t *testing.T) {
+		blocks := parseMarkdown("```go\nfmt.Println(\"*not emphasis*\")\n\nx := 1\n```\nAfter")
+		if len(blocks) != 2 || blocks[0].Kind != mdCodeBlock {
+			t.Fatalf("parseMarkdown() = %+v; want code block then paragraph", blocks)
+		}
+		if blocks[0].Lang != "go" {
+			t.Errorf("Code block lang = %q; want %q", blocks[0].Lang, "go")
+		}
+		if blocks[0].Text != "fmt.Println(\"*not emphasis*\")\n\nx := 1" {
+			t.Errorf("Code block text = %q", blocks[0].Text)
+		}
+	})
+
+	t.Run("bullet and numbered lists", func(t *testing.T) {
+		blocks := parseMarkdown("- one\n- two\n  continued\n\n1. first\n2. second")
+		if len(blocks) != 2 {
+			t.Fatalf("parseMarkdown() returned %d blocks; want 2: %+v", len(blocks), blocks)
+		}
+		if blocks[0].Kind != mdList || blocks[0].Ordered || len(blocks[0].Items) != 2 {
+			t.Errorf("First list = %+v; want 2 bullet items", blocks[0])
+		}
+		if blocks[0].Items[1] != "two continued" {
+			t.Errorf("Continuation line not joined: %q", blocks[0].Items[1])
+		}
+		if blocks[1].Kind != mdList || !blocks[1].Ordered || len(blocks[1].Items) != 2 {
+			t.Errorf("Second list = %+v; want 2 numbered items", blocks[1])
+		}
+	})
+
+	t.Run("blockquote and rule", func(t *testing.T) {
+		blocks := parseMarkdown("> quoted\n> text\n\n---")
+		if len(blocks) != 2 || blocks[0].Kind != mdQuote || blocks[1].Kind != mdRule {
+			t.Fatalf("parseMarkdown() = %+v; want quote then rule", blocks)
+		}
+		if blocks[0].Text != "quoted text" {
+			t.Errorf("Quote text = %q; want %q", blocks[0].Text, "quoted text")
+		}
+	})
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []mdInline
+	}{
+		{
+			name:     "plain text",
+			input:    "just text",
+			expected: []mdInline{{Kind: mdText, Text: "just text"}},
+		},
+		{
+			name:  "bold and italic",
+			input: "**bold** and *italic*",
+			expected: []mdInline{
+				{Kind: mdBold, Text: "bold"},
+				{Kind: mdText, Text: " and "},
+				{Kind: mdItalic, Text: "italic"},
+			},
+		},
+		{
+			name:  "inline code keeps markup",
+			input: "use `*ptr` here",
+			expected: []mdInline{
+				{Kind: mdText, Text: "use "},
+				{Kind: mdCode, Text: "*ptr"},
+				{Kind: mdText, Text: " here"},
+			},
+		},
+		{
+			name:  "link",
+			input: "see [docs](https://go.dev)",
+			expected: []mdInline{
+				{Kind: mdText, Text: "see "},
+				{Kind: mdLink, Text: "docs", URL: "https://go.dev"},
+			},
+		},
+		{
+			name:  "bracketed text before a link",
+			input: "[a] and [b](c)",
+			expected: []mdInline{
+				{Kind: mdText, Text: "[a] and "},
+				{Kind: mdLink, Text: "b", URL: "c"},
+			},
+		},
+		{
+			name:  "brackets inside a link label",
+			input: "[see [1]](https://go.dev)",
+			expected: []mdInline{
+				{Kind: mdLink, Text: "see [1]", URL: "https://go.dev"},
+			},
+		},
+		{
+			name:     "snake_case is not emphasis",
+			input:    "use snake_case_names",
+			expected: []mdInline{{Kind: mdText, Text: "use snake_case_names"}},
+		},
+		{
+			name:     "lone asterisk is text",
+			input:    "a * b",
+			expected: []mdInline{{Kind: mdText, Text: "a * b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseInline(tt.input)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("parseInline(%q) = %+v; want %+v", tt.input, result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("parseInline(%q)[%d] = %+v; want %+v", tt.input, i, result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The org, adoc and rst formats mirror formatMarkdown_output: the query as a
+// heading, the answer converted from markdown, then a numbered references
+// list with each snippet quoted. Quiet mode outputs the converted answer only.
+
+// formatOrg_output renders the response as an Emacs Org-mode document.
+func formatOrg_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if config.Quiet {
+		output.WriteString(markdownToOrg(resp.Data.Output, 0))
+		return output.String()
+	}
+
+	output.WriteString("* ")
+	output.WriteString(oneLine(config.Question))
+	output.WriteString("\n\n")
+
+	// Answer headings become sub-headings of the query heading
+	output.WriteString(markdownToOrg(resp.Data.Output, 1))
+
+	if len(resp.Data.References) > 0 {
+		output.WriteString("\n** References\n\n")
+
+		for i, ref := range resp.Data.References {
+			output.WriteString(fmt.Sprintf("%d. %s\n", i+1, orgLink(ref.Title, ref.URL)))
+
+			if ref.Snippet != "" {
+				output.WriteString("   #+begin_quote\n   ")
+				output.WriteString(oneLine(ref.Snippet))
+				output.WriteString("\n   #+end_quote\n")
+			}
+		}
+	}
+
+	return output.String()
+}
+
+// formatAsciiDoc_output renders the response as an AsciiDoc document.
+func formatAsciiDoc_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if config.Quiet {
+		output.WriteString(markdownToAsciiDoc(resp.Data.Output, 1))
+		return output.String()
+	}
+
+	output.WriteString("= ")
+	output.WriteString(oneLine(config.Question))
+	output.WriteString("\n\n")
+
+	output.WriteString(markdownToAsciiDoc(resp.Data.Output, 1))
+
+	if len(resp.Data.References) > 0 {
+		output.WriteString("\n== References\n\n")
+
+		for _, ref := range resp.Data.References {
+			output.WriteString(". ")
+			output.WriteString(asciiDocLink(ref.Title, ref.URL))
+			output.WriteString("\n")
+
+			if ref.Snippet != "" {
+				// The continuation marker attaches the quote to the list item
+				output.WriteString("+\n____\n")
+				output.WriteString(asciiDocEscape(oneLine(ref.Snippet)))
+				output.WriteString("\n____\n")
+			}
+		}
+	}
+
+	return output.String()
+}
+
+// formatRST_output renders the response as a reStructuredText document.
+func formatRST_output(resp *FastGPTResponse, config *Config) string {
+	var output strings.Builder
+
+	if config.Quiet {
+		output.WriteString(markdownToRST(resp.Data.Output))
+		return output.String()
+	}
+
+	output.WriteString(rstHeading(oneLine(config.Question), '='))
+	output.WriteString("\n")
+
+	output.WriteString(markdownToRST(resp.Data.Output))
+
+	if len(resp.Data.References) > 0 {
+		output.WriteString("\n")
+		output.WriteString(rstHeading("References", '-'))
+		output.WriteString("\n")
+
+		for i, ref := range resp.Data.References {
+			if i > 0 {
+				output.WriteString("\n")
+			}
+			output.WriteString("#. ")
+			output.WriteString(rstLink(ref.Title, ref.URL))
+			output.WriteString("\n")
+
+			if ref.Snippet != "" {
+				// Indented past the list item body, which makes it a block quote
+				output.WriteString("\n      ")
+				output.WriteString(rstEscape(oneLine(ref.Snippet)))
+				output.WriteString("\n")
+			}
+		}
+	}
+
+	return output.String()
+}
+
+// oneLine collapses whitespace so API-provided text cannot start new block
+// elements in line-oriented markup.
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Org-mode
+
+func markdownToOrg(src string, headingOffset int) string {
+	var out strings.Builder
+
+	for i, block := range parseMarkdown(src) {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+
+		switch block.Kind {
+		case mdHeading:
+			out.WriteString(strings.Repeat("*", block.Level+headingOffset))
+			out.WriteString(" ")
+			out.WriteString(inlineToOrg(block.Text))
+			out.WriteString("\n")
+		case mdCodeBlock:
+			out.WriteString("#+begin_src")
+			if block.Lang != "" {
+				out.WriteString(" " + block.Lang)
+			}
+			out.WriteString("\n")
+			// A comma escapes lines that Org would otherwise read as syntax
+			for _, line := range strings.Split(block.Text, "\n") {
+				if strings.HasPrefix(line, "*") || strings.HasPrefix(strings.TrimSpace(line), "#+") {
+					out.WriteString(",")
+				}
+				out.WriteString(line)
+				out.WriteString("\n")
+			}
+			out.WriteString("#+end_src\n")
+		case mdList:
+			for n, item := range block.Items {
+				if block.Ordered {
+					fmt.Fprintf(&out, "%d. ", n+1)
+				} else {
+					out.WriteString("- ")
+				}
+				out.WriteString(inlineToOrg(item))
+				out.WriteString("\n")
+			}
+		case mdQuote:
+			out.WriteString("#+begin_quote\n")
+			out.WriteString(inlineToOrg(block.Text))
+			out.WriteString("\n#+end_quote\n")
+		case mdRule:
+			out.WriteString("-----\n")
+		default:
+			out.WriteString(inlineToOrg(block.Text))
+			out.WriteString("\n")
+		}
+	}
+
+	return out.String()
+}
+
+func inlineToOrg(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		switch span.Kind {
+		case mdBold:
+			out.WriteString("*" + span.Text + "*")
+		case mdItalic:
+			out.WriteString("/" + span.Text + "/")
+		case mdCode:
+			out.WriteString("~" + span.Text + "~")
+		case mdLink:
+			out.WriteString(orgLink(span.Text, span.URL))
+		default:
+			out.WriteString(span.Text)
+		}
+	}
+
+	return out.String()
+}
+
+func orgLink(text, url string) string {
+	url = strings.NewReplacer("[", "%5B", "]", "%5D", " ", "%20").Replace(strings.TrimSpace(url))
+	// "]]" would end the link early; single brackets are fine in the description
+	text = strings.ReplaceAll(oneLine(text), "]]", "] ]")
+	if url == "" {
+		return text
+	}
+	if text == "" {
+		return "[[" + url + "]]"
+	}
+	return "[[" + url + "][" + text + "]]"
+}
+
+// AsciiDoc
+
+func markdownToAsciiDoc(src string, headingOffset int) string {
+	var out strings.Builder
+
+	for i, block := range parseMarkdown(src) {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+
+		switch block.Kind {
+		case mdHeading:
+			out.WriteString(strings.Repeat("=", min(block.Level+headingOffset, 6)))
+			out.WriteString(" ")
+			out.WriteString(inlineToAsciiDoc(block.Text))
+			out.WriteString("\n")
+		case mdCodeBlock:
+			if block.Lang != "" {
+				out.WriteString("[source," + block.Lang + "]\n")
+			}
+			out.WriteString("----\n")
+			out.WriteString(block.Text)
+			out.WriteString("\n----\n")
+		case mdList:
+			marker := "*"
+			if block.Ordered {
+				marker = "."
+			}
+			for _, item := range block.Items {
+				out.WriteString(marker + " ")
+				out.WriteString(inlineToAsciiDoc(item))
+				out.WriteString("\n")
+			}
+		case mdQuote:
+			out.WriteString("____\n")
+			out.WriteString(inlineToAsciiDoc(block.Text))
+			out.WriteString("\n____\n")
+		case mdRule:
+			out.WriteString("'''\n")
+		default:
+			out.WriteString(inlineToAsciiDoc(block.Text))
+			out.WriteString("\n")
+		}
+	}
+
+	return out.String()
+}
+
+func inlineToAsciiDoc(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		switch span.Kind {
+		case mdBold:
+			out.WriteString("*" + asciiDocEscape(span.Text) + "*")
+		case mdItalic:
+			out.WriteString("_" + asciiDocEscape(span.Text) + "_")
+		case mdCode:
+			// The passthrough stops AsciiDoc interpreting markup inside code
+			out.WriteString("`+" + span.Text + "+`")
+		case mdLink:
+			out.WriteString(asciiDocLink(span.Text, span.URL))
+		default:
+			out.WriteString(asciiDocEscape(span.Text))
+		}
+	}
+
+	return out.String()
+}
+
+func asciiDocLink(text, url string) string {
+	url = strings.TrimSpace(url)
+	text = strings.ReplaceAll(asciiDocEscape(oneLine(text)), "]", "\\]")
+	if url == "" {
+		return text
+	}
+	// The ++ passthrough keeps characters such as [ ] and _ in the URL literal
+	return "link:++" + url + "++[" + text + "]"
+}
+
+// asciiDocEscape prevents API-provided text from being read as attribute
+// references or passthrough markers.
+func asciiDocEscape(text string) string {
+	return strings.NewReplacer("{", "\\{", "++", "\\++").Replace(text)
+}
+
+// reStructuredText
+
+// rstSectionChars are the underline characters for answer headings, which
+// sit below the query title ('=') and alongside References ('-').
+var rstSectionChars = []byte{'-', '~', '^', '"'}
+
+func markdownToRST(src string) string {
+	var out strings.Builder
+
+	for i, block := range parseMarkdown(src) {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+
+		switch block.Kind {
+		case mdHeading:
+			char := rstSectionChars[min(block.Level, len(rstSectionChars))-1]
+			out.WriteString(rstHeading(inlineToRST(block.Text), char))
+		case mdCodeBlock:
+			if block.Lang != "" {
+				out.WriteString(".. code-block:: " + block.Lang + "\n\n")
+			} else {
+				out.WriteString("::\n\n")
+			}
+			for _, line := range strings.Split(block.Text, "\n") {
+				if line != "" {
+					out.WriteString("   " + line)
+				}
+				out.WriteString("\n")
+			}
+		case mdList:
+			marker := "-"
+			if block.Ordered {
+				marker = "#."
+			}
+			for _, item := range block.Items {
+				out.WriteString(marker + " ")
+				out.WriteString(inlineToRST(item))
+				out.WriteString("\n")
+			}
+		case mdQuote:
+			out.WriteString("   ")
+			out.WriteString(inlineToRST(block.Text))
+			out.WriteString("\n")
+		case mdRule:
+			out.WriteString("----\n")
+		default:
+			out.WriteString(inlineToRST(block.Text))
+			out.WriteString("\n")
+		}
+	}
+
+	return out.String()
+}
+
+func inlineToRST(src string) string {
+	var out strings.Builder
+
+	for _, span := range parseInline(src) {
+		switch span.Kind {
+		case mdBold:
+			out.WriteString("**" + rstEscape(span.Text) + "**")
+		case mdItalic:
+			out.WriteString("*" + rstEscape(span.Text) + "*")
+		case mdCode:
+			out.WriteString("``" + span.Text + "``")
+		case mdLink:
+			out.WriteString(rstLink(span.Text, span.URL))
+		default:
+			out.WriteString(rstEscape(span.Text))
+		}
+	}
+
+	return out.String()
+}
+
+func rstHeading(text string, underline byte) string {
+	// The byte length is never less than the display width, and an
+	// underline longer than the title is valid
+	return text + "\n" + strings.Repeat(string(underline), max(len(text), 3)) + "\n"
+}
+
+func rstLink(text, url string) string {
+	url = strings.NewReplacer("<", "%3C", ">", "%3E", "`", "%60", " ", "%20").Replace(strings.TrimSpace(url))
+	text = rstEscape(oneLine(text))
+	if url == "" {
+		return text
+	}
+	if text == "" {
+		text = url
+	}
+	// Anonymous (double underscore) links avoid duplicate target name errors
+	return "`" + strings.NewReplacer("<", "\\<").Replace(text) + " <" + url + ">`__"
+}
+
+// rstEscape backslash-escapes characters that start inline markup.
+func rstEscape(text string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		"*", "\\*",
+		"`", "\\`",
+		"_", "\\_",
+		"|", "\\|",
+	).Replace(text)
+}
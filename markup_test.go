@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatOrg_output(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("basic org output", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatOrg}
+
+		result := formatOrg_output(resp, config)
+
+		if !strings.HasPrefix(result, "* test query\n") {
+			t.Errorf("Org output missing heading")
+		}
+		if !strings.Contains(result, "This is a test response") {
+			t.Errorf("Org output missing response text")
+		}
+		if !strings.Contains(result, "** References") {
+			t.Errorf("Org output missing references section")
+		}
+		if !strings.Contains(result, "1. [[https://example.com/1][Test Reference 1]]") {
+			t.Errorf("Org output missing link for first reference")
+		}
+		if !strings.Contains(result, "#+begin_quote\n   First test snippet\n   #+end_quote") {
+			t.Errorf("Org output missing quoted snippet")
+		}
+	})
+
+	t.Run("quiet mode", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatOrg, Quiet: true}
+
+		result := formatOrg_output(resp, config)
+
+		if result != "This is a test response\n" {
+			t.Errorf("Quiet org output = %q", result)
+		}
+	})
+}
+
+func TestMarkdownToOrg(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"emphasis and code", "**bold** *italic* `code`", "*bold* /italic/ ~code~\n"},
+		{"heading offset", "## Usage", "*** Usage\n"},
+		{"link", "[Go](https://go.dev)", "[[https://go.dev][Go]]\n"},
+		{"code block escapes stars", "```go\n*p = 1\n```", "#+begin_src go\n,*p = 1\n#+end_src\n"},
+		{"lists", "- a\n- b", "- a\n- b\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := markdownToOrg(tt.input, 1); result != tt.expected {
+				t.Errorf("markdownToOrg(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatAsciiDoc_output(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("basic asciidoc output", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatAsciiDoc}
+
+		result := formatAsciiDoc_output(resp, config)
+
+		if !strings.HasPrefix(result, "= test query\n") {
+			t.Errorf("AsciiDoc output missing document title")
+		}
+		if !strings.Contains(result, "== References") {
+			t.Errorf("AsciiDoc output missing references section")
+		}
+		if !strings.Contains(result, ". link:++https://example.com/1++[Test Reference 1]") {
+			t.Errorf("AsciiDoc output missing link for first reference")
+		}
+		if !strings.Contains(result, "+\n____\nFirst test snippet\n____") {
+			t.Errorf("AsciiDoc output missing quoted snippet")
+		}
+	})
+
+	t.Run("quiet mode", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatAsciiDoc, Quiet: true}
+
+		result := formatAsciiDoc_output(resp, config)
+
+		if strings.Contains(result, "= test query") || strings.Contains(result, "References") {
+			t.Errorf("Quiet AsciiDoc output should contain body only: %q", result)
+		}
+	})
+
+	t.Run("brackets in titles escaped", func(t *testing.T) {
+		result := asciiDocLink("Title [with] brackets", "https://example.com")
+		if result != "link:++https://example.com++[Title [with\\] brackets]" {
+			t.Errorf("asciiDocLink() = %q", result)
+		}
+	})
+}
+
+func TestMarkdownToAsciiDoc(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"emphasis and code", "**bold** *italic* `a*b`", "*bold* _italic_ `+a*b+`\n"},
+		{"heading offset", "# Usage", "== Usage\n"},
+		{"code block", "```go\nx := 1\n```", "[source,go]\n----\nx := 1\n----\n"},
+		{"numbered list", "1. a\n2. b", ". a\n. b\n"},
+		{"attribute references escaped", "use {name}", "use \\{name}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := markdownToAsciiDoc(tt.input, 1); result != tt.expected {
+				t.Errorf("markdownToAsciiDoc(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatRST_output(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("basic rst output", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatRST}
+
+		result := formatRST_output(resp, config)
+
+		if !strings.HasPrefix(result, "test query\n==========\n") {
+			t.Errorf("RST output missing title")
+		}
+		if !strings.Contains(result, "References\n----------") {
+			t.Errorf("RST output missing references section")
+		}
+		if !strings.Contains(result, "#. `Test Reference 1 <https://example.com/1>`__") {
+			t.Errorf("RST output missing link for first reference")
+		}
+		if !strings.Contains(result, "\n\n      First test snippet\n") {
+			t.Errorf("RST output missing quoted snippet")
+		}
+	})
+
+	t.Run("quiet mode", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatRST, Quiet: true}
+
+		result := formatRST_output(resp, config)
+
+		if result != "This is a test response\n" {
+			t.Errorf("Quiet RST output = %q", result)
+		}
+	})
+
+	t.Run("title underline covers unicode", func(t *testing.T) {
+		config := &Config{Question: "测试查询", Format: formatRST, Quiet: false}
+
+		result := formatRST_output(resp, config)
+		lines := strings.SplitN(result, "\n", 3)
+
+		// Wide characters take two columns, so the underline must be at least twice the rune count
+		if len(lines[1]) < 2*len([]rune(lines[0])) {
+			t.Errorf("RST underline %q too short for title %q", lines[1], lines[0])
+		}
+	})
+}
+
+func TestMarkdownToRST(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"emphasis and code", "**bold** *italic* `a*b`", "**bold** *italic* ``a*b``\n"},
+		{"special characters escaped", "a_b *c", "a\\_b \\*c\n"},
+		{"heading", "## Usage", "Usage\n~~~~~\n"},
+		{"code block", "```go\nx := 1\n```", ".. code-block:: go\n\n   x := 1\n"},
+		{"code block without language", "```\nx\n```", "::\n\n   x\n"},
+		{"numbered list", "1. a\n2. b", "#. a\n#. b\n"},
+		{"link", "[Go](https://go.dev)", "`Go <https://go.dev>`__\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := markdownToRST(tt.input); result != tt.expected {
+				t.Errorf("markdownToRST(%q) = %q; want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// The mcp command serves Kagi FastGPT to AI assistants as a Model Context
+// Protocol tool. Messages are newline-delimited JSON-RPC 2.0 on stdin and
+// stdout; stderr is free for logging.
+
+const (
+	mcpProtocolVersion = "2025-06-18"
+	mcpServerName      = "kagi"
+	mcpToolFastGPT     = "fastgpt_query"
+
+	// mcpMaxMessageSize bounds a single JSON-RPC message
+	mcpMaxMessageSize = 4 * 1024 * 1024
+
+	// JSON-RPC error codes
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// mcpProtocolVersions are the protocol versions the server can speak.
+var mcpProtocolVersions = []string{mcpProtocolVersion, "2025-03-26", "2024-11-05"}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name         string         `json:"name"`
+	Title        string         `json:"title"`
+	Description  string         `json:"description"`
+	InputSchema  map[string]any `json:"inputSchema"`
+	OutputSchema map[string]any `json:"outputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+// mcpFastGPTResult is the structured content of a fastgpt_query result.
+type mcpFastGPTResult struct {
+	Output     string      `json:"output"`
+	Tokens     int         `json:"tokens"`
+	References []Reference `json:"references"`
+}
+
+// mcpServer answers MCP requests. query is queryKagi outside of tests.
+type mcpServer struct {
+	apiKey  string
+	timeout int
+	query   func(ctx context.Context, apiKey, query string, timeout int) (*FastGPTResponse, error)
+	debug   bool
+}
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Run a Model Context Protocol server on stdio",
+	Long: `Run a Model Context Protocol (MCP) server over stdio, exposing Kagi FastGPT
+to AI assistants as the fastgpt_query tool.
+
+Example client configuration:
+
+  {"mcpServers": {"kagi": {"command": "kagi", "args": ["mcp"]}}}`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apiKey, err := resolveAPIKey()
+		if err != nil {
+			return err
+		}
+		if flagTimeout <= 0 {
+			return fmt.Errorf("invalid timeout value %q\nTimeout must be a positive integer (seconds)", fmt.Sprint(flagTimeout))
+		}
+		if err := configureTransport(); err != nil {
+			return err
+		}
+
+		if flagMetricsAddr != "" {
+			metrics = newKagiMetrics()
+			if err := serveMetrics(flagMetricsAddr); err != nil {
+				return err
+			}
+		}
+
+		server := &mcpServer{
+			apiKey:  apiKey,
+			timeout: flagTimeout,
+			query:   queryKagi,
+			debug:   flagDebug,
+		}
+		// A signal is how clients stop the server, so it is not an error
+		if err := server.serve(cmd.Context(), cmd.InOrStdin(), cmd.OutOrStdout()); !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	mcpCmd.Flags().StringVar(&flagAPIKey, "api-key", "", "Kagi API key (overrides KAGI_API_KEY env var)")
+	mcpCmd.Flags().IntVarP(&flagTimeout, "timeout", "t", defaultTimeout, "HTTP request timeout in seconds")
+	addTransportFlags(mcpCmd)
+	mcpCmd.Flags().StringVar(&flagMetricsAddr, "metrics-addr", "", "Serve Prometheus /metrics on this address, e.g. 127.0.0.1:9090")
+	mcpCmd.Flags().BoolVar(&flagDebug, "debug", false, "Log JSON-RPC messages to stderr")
+	rootCmd.AddCommand(mcpCmd)
+}
+
+// serve reads requests from r until EOF or ctx is cancelled, writing one
+// response per line to w.
+func (s *mcpServer) serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	// A blocked read cannot be interrupted, so read in the background and
+	// stop waiting for it on cancellation
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), mcpMaxMessageSize)
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+		close(lines)
+	}()
+
+	encoder := json.NewEncoder(w)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var line []byte
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case next, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != nil {
+					return fmt.Errorf("failed to read MCP request: %w", err)
+				}
+				return nil
+			}
+			line = bytes.TrimSpace(next)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if s.debug {
+			fmt.Fprintf(os.Stderr, "Debug: <- %s\n", line)
+		}
+
+		resp := s.handle(ctx, line)
+		if resp == nil {
+			continue
+		}
+		if s.debug {
+			if jsonBytes, err := json.Marshal(resp); err == nil {
+				fmt.Fprintf(os.Stderr, "Debug: -> %s\n", jsonBytes)
+			}
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return fmt.Errorf("failed to write MCP response: %w", err)
+		}
+	}
+}
+
+// handle processes one message, returning nil for notifications.
+func (s *mcpServer) handle(ctx context.Context, message []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return rpcErrorResponse(nil, rpcParseError, "parse error: "+err.Error())
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, rpcInvalidRequest, "invalid request")
+	}
+
+	// Notifications have no id and never receive a response
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	switch req.Method {
+	case "initialize":
+		return rpcResult(req.ID, s.initialize(req.Params))
+	case "ping":
+		return rpcResult(req.ID, struct{}{})
+	case "tools/list":
+		return rpcResult(req.ID, map[string]any{"tools": []mcpTool{fastGPTTool()}})
+	case "tools/call":
+		result, rpcErr := s.callTool(ctx, req.Params)
+		if rpcErr != nil {
+			return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr}
+		}
+		return rpcResult(req.ID, result)
+	default:
+		return rpcErrorResponse(req.ID, rpcMethodNotFound, fmt.Sprintf("method %q not found", req.Method))
+	}
+}
+
+func (s *mcpServer) initialize(params json.RawMessage) map[string]any {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	_ = json.Unmarshal(params, &p)
+
+	// Agree to the client's version if supported, otherwise offer the latest
+	protocolVersion := mcpProtocolVersion
+	for _, v := range mcpProtocolVersions {
+		if v == p.ProtocolVersion {
+			protocolVersion = v
+		}
+	}
+
+	return map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities": map[string]any{
+			"tools": map[string]any{},
+		},
+		"serverInfo": map[string]any{
+			"name":    mcpServerName,
+			"version": version,
+		},
+	}
+}
+
+// callTool runs a tool. Unknown tools and malformed arguments are protocol
+// errors; API failures are tool errors so the model can see and react to them.
+func (s *mcpServer) callTool(ctx context.Context, params json.RawMessage) (*mcpToolResult, *rpcError) {
+	var p struct {
+		Name      string `json:"name"`
+		Arguments struct {
+			Query string `json:"query"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	if p.Name != mcpToolFastGPT {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("unknown tool %q", p.Name)}
+	}
+
+	query := strings.TrimSpace(p.Arguments.Query)
+	if query == "" {
+		return toolError("query is required"), nil
+	}
+
+	resp, err := s.query(ctx, s.apiKey, query, s.timeout)
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	references := resp.Data.References
+	if references == nil {
+		references = []Reference{}
+	}
+
+	return &mcpToolResult{
+		Content: []mcpContent{{Type: "text", Text: mcpText(resp)}},
+		StructuredContent: mcpFastGPTResult{
+			Output:     resp.Data.Output,
+			Tokens:     resp.Data.Tokens,
+			References: references,
+		},
+	}, nil
+}
+
+// mcpText is the answer with its references, for clients that do not use
+// structured content.
+func mcpText(resp *FastGPTResponse) string {
+	var text strings.Builder
+	text.WriteString(resp.Data.Output)
+
+	if len(resp.Data.References) > 0 {
+		text.WriteString("\n\nReferences:\n")
+		for i, ref := range resp.Data.References {
+			text.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, ref.Title, ref.URL))
+		}
+	}
+
+	return strings.TrimRight(text.String(), "\n")
+}
+
+func fastGPTTool() mcpTool {
+	return mcpTool{
+		Name:  mcpToolFastGPT,
+		Title: "Kagi FastGPT",
+		Description: "Answer a question using Kagi FastGPT, which searches the web and " +
+			"returns a concise answer with numbered citations such as 【1】 and the " +
+			"references they point to.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "The question to answer",
+				},
+			},
+			"required": []string{"query"},
+		},
+		OutputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"output": map[string]any{"type": "string", "description": "The answer, in markdown"},
+				"tokens": map[string]any{"type": "integer"},
+				"references": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"title":   map[string]any{"type": "string"},
+							"snippet": map[string]any{"type": "string"},
+							"url":     map[string]any{"type": "string"},
+						},
+						"required": []string{"title", "snippet", "url"},
+					},
+				},
+			},
+			"required": []string{"output", "tokens", "references"},
+		},
+	}
+}
+
+func toolError(message string) *mcpToolResult {
+	return &mcpToolResult{
+		Content: []mcpContent{{Type: "text", Text: message}},
+		IsError: true,
+	}
+}
+
+func rpcResult(id json.RawMessage, result any) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestMCPServer() *mcpServer {
+	return &mcpServer{
+		apiKey:  "test-key",
+		timeout: defaultTimeout,
+		query: func(ctx context.Context, apiKey, query string, timeout int) (*FastGPTResponse, error) {
+			if query == "fail" {
+				return nil, errors.New("API rate limit exceeded, try again later")
+			}
+			return createTestResponse(), nil
+		},
+	}
+}
+
+// mcpExchange sends newline-delimited requests and decodes each response.
+func mcpExchange(t *testing.T, server *mcpServer, requests ...string) []map[string]any {
+	t.Helper()
+
+	var out strings.Builder
+	if err := server.serve(context.Background(), strings.NewReader(strings.Join(requests, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("serve failed: %v", err)
+	}
+
+	var responses []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var resp map[string]any
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("Response is not valid JSON: %v\n%s", err, line)
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func TestMCPServer(t *testing.T) {
+	t.Run("initialize", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(),
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+			`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		)
+		if len(responses) != 1 {
+			t.Fatalf("Expected 1 response (notifications get none), got %d", len(responses))
+		}
+		result := responses[0]["result"].(map[string]any)
+		if result["protocolVersion"] != "2025-03-26" {
+			t.Errorf("protocolVersion = %v; want the client's supported version", result["protocolVersion"])
+		}
+		if _, ok := result["capabilities"].(map[string]any)["tools"]; !ok {
+			t.Errorf("Missing tools capability: %v", result)
+		}
+	})
+
+	t.Run("unsupported protocol version gets latest", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(),
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+		result := responses[0]["result"].(map[string]any)
+		if result["protocolVersion"] != mcpProtocolVersion {
+			t.Errorf("protocolVersion = %v; want %s", result["protocolVersion"], mcpProtocolVersion)
+		}
+	})
+
+	t.Run("tools/list", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(), `{"jsonrpc":"2.0","id":"a","method":"tools/list"}`)
+		if responses[0]["id"] != "a" {
+			t.Errorf("id = %v; want a", responses[0]["id"])
+		}
+		tools := responses[0]["result"].(map[string]any)["tools"].([]any)
+		if len(tools) != 1 || tools[0].(map[string]any)["name"] != mcpToolFastGPT {
+			t.Errorf("Unexpected tools: %v", tools)
+		}
+	})
+
+	t.Run("tools/call returns answer and references", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(),
+			`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"fastgpt_query","arguments":{"query":"test"}}}`)
+		result := responses[0]["result"].(map[string]any)
+		if result["isError"] == true {
+			t.Fatalf("Unexpected tool error: %v", result)
+		}
+		text := result["content"].([]any)[0].(map[string]any)["text"].(string)
+		if !strings.Contains(text, "This is a test response") || !strings.Contains(text, "https://example.com/1") {
+			t.Errorf("Text content missing answer or references: %q", text)
+		}
+		structured := result["structuredContent"].(map[string]any)
+		if structured["output"] != "This is a test response" {
+			t.Errorf("structuredContent.output = %v", structured["output"])
+		}
+		if refs := structured["references"].([]any); len(refs) != 2 {
+			t.Errorf("Expected 2 references, got %d", len(refs))
+		}
+	})
+
+	t.Run("API errors are tool errors", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(),
+			`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"fastgpt_query","arguments":{"query":"fail"}}}`)
+		result := responses[0]["result"].(map[string]any)
+		if result["isError"] != true {
+			t.Errorf("Expected isError, got %v", result)
+		}
+		text := result["content"].([]any)[0].(map[string]any)["text"].(string)
+		if !strings.Contains(text, "rate limit") {
+			t.Errorf("Tool error should carry the API error: %q", text)
+		}
+	})
+
+	t.Run("missing query is a tool error", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(),
+			`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"fastgpt_query","arguments":{}}}`)
+		if responses[0]["result"].(map[string]any)["isError"] != true {
+			t.Errorf("Expected isError for missing query: %v", responses[0])
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		request string
+		code    float64
+	}{
+		{"unknown tool", `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"nope","arguments":{}}}`, rpcInvalidParams},
+		{"unknown method", `{"jsonrpc":"2.0","id":6,"method":"resources/list"}`, rpcMethodNotFound},
+		{"parse error", `{not json`, rpcParseError},
+		{"wrong version", `{"jsonrpc":"1.0","id":7,"method":"ping"}`, rpcInvalidRequest},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := mcpExchange(t, newTestMCPServer(), tt.request)
+			if len(responses) != 1 {
+				t.Fatalf("Expected 1 response, got %d", len(responses))
+			}
+			rpcErr, ok := responses[0]["error"].(map[string]any)
+			if !ok {
+				t.Fatalf("Expected error response, got %v", responses[0])
+			}
+			if rpcErr["code"] != tt.code {
+				t.Errorf("error.code = %v; want %v", rpcErr["code"], tt.code)
+			}
+		})
+	}
+
+	t.Run("ping", func(t *testing.T) {
+		responses := mcpExchange(t, newTestMCPServer(), `{"jsonrpc":"2.0","id":8,"method":"ping"}`)
+		if _, ok := responses[0]["result"].(map[string]any); !ok {
+			t.Errorf("Expected empty result, got %v", responses[0])
+		}
+	})
+}
+
+func TestMCPServerCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out strings.Builder
+	err := newTestMCPServer().serve(ctx, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`+"\n"), &out)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("serve() = %v; want context.Canceled", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Cancelled server should not answer: %q", out.String())
+	}
+}
+
+func TestMCPCommandStopsCleanly(t *testing.T) {
+	t.Setenv(envAPIKey, "test-key")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mcpCmd.SetContext(ctx)
+	mcpCmd.SetIn(strings.NewReader(""))
+	defer mcpCmd.SetIn(nil)
+	if err := mcpCmd.RunE(mcpCmd, nil); err != nil {
+		t.Errorf("A signal should stop the server without an error, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics for the long-running commands (serve, mcp), written in
+// the text exposition format. metrics is nil unless one of those commands
+// enables it; every method is a no-op on a nil receiver, so one-shot queries
+// pay nothing.
+
+const (
+	metricsPath = "/metrics"
+
+	// API request outcomes
+	outcomeSuccess         = "success"
+	outcomeAPIError        = "api_error"
+	outcomeTimeout         = "timeout"
+	outcomeCancelled       = "cancelled"
+	outcomeNetworkError    = "network_error"
+	outcomeInvalidResponse = "invalid_response"
+)
+
+// durationBuckets are histogram bucket upper bounds in seconds.
+var durationBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+
+var metrics *kagiMetrics
+
+type kagiMetrics struct {
+	mu sync.Mutex
+
+	apiRequests      map[[2]string]float64 // outcome, status
+	apiDuration      *histogram
+	reportedDuration *histogram
+	tokens           float64
+	cache            map[string]float64    // result
+	httpRequests     map[[2]string]float64 // path, status
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, plus +Inf
+	sum    float64
+	count  uint64
+}
+
+func newKagiMetrics() *kagiMetrics {
+	return &kagiMetrics{
+		apiRequests:      make(map[[2]string]float64),
+		apiDuration:      newHistogram(),
+		reportedDuration: newHistogram(),
+		cache:            make(map[string]float64),
+		httpRequests:     make(map[[2]string]float64),
+	}
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+}
+
+func (h *histogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// observeAPIRequest records one FastGPT API call. status is 0 when no HTTP
+// response was received; resp is nil unless the call succeeded.
+func (m *kagiMetrics) observeAPIRequest(outcome string, status int, elapsed time.Duration, resp *FastGPTResponse) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statusLabel := "none"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+	m.apiRequests[[2]string{outcome, statusLabel}]++
+	m.apiDuration.observe(elapsed.Seconds())
+
+	if resp != nil {
+		m.reportedDuration.observe(float64(resp.Meta.MS) / 1000)
+		m.tokens += float64(resp.Data.Tokens)
+	}
+}
+
+// observeCache records a gateway cache lookup: "hit" or "miss".
+func (m *kagiMetrics) observeCache(result string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache[result]++
+}
+
+// observeHTTPRequest records a request served by the gateway.
+func (m *kagiMetrics) observeHTTPRequest(path string, status int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.httpRequests[[2]string{path, strconv.Itoa(status)}]++
+}
+
+// writeTo writes every metric in the Prometheus text format.
+func (m *kagiMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounterVec(w, "kagi_api_requests_total", "FastGPT API requests by outcome and HTTP status.",
+		[]string{"outcome", "status"}, m.apiRequests)
+	writeHistogram(w, "kagi_api_request_duration_seconds", "Observed wall time of FastGPT API requests.", m.apiDuration)
+	writeHistogram(w, "kagi_api_reported_duration_seconds", "Processing time reported by Kagi (meta.ms) for successful requests.", m.reportedDuration)
+
+	fmt.Fprintf(w, "# HELP kagi_api_tokens_total Tokens consumed by successful FastGPT requests.\n")
+	fmt.Fprintf(w, "# TYPE kagi_api_tokens_total counter\n")
+	fmt.Fprintf(w, "kagi_api_tokens_total %s\n", formatMetric(m.tokens))
+
+	// Always list both results so rates can be computed from the start
+	cacheSeries := make(map[string]float64)
+	for _, result := range []string{"hit", "miss"} {
+		cacheSeries[labels([]string{"result"}, []string{result})] = m.cache[result]
+	}
+	writeCounter(w, "kagi_cache_requests_total", "Gateway cache lookups by result.", cacheSeries)
+
+	writeCounterVec(w, "kagi_http_requests_total", "Requests served by kagi serve by path and HTTP status.",
+		[]string{"path", "status"}, m.httpRequests)
+}
+
+func writeCounterVec(w io.Writer, name, help string, labelNames []string, counts map[[2]string]float64) {
+	series := make(map[string]float64, len(counts))
+	for key, count := range counts {
+		series[labels(labelNames, key[:])] = count
+	}
+	writeCounter(w, name, help, series)
+}
+
+func writeCounter(w io.Writer, name, help string, series map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatMetric(series[key]))
+	}
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	// Bucket counts are cumulative
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatMetric(bound), cumulative)
+	}
+	cumulative += h.counts[len(durationBuckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatMetric(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// labels formats a label set such as {outcome="success",status="200"}.
+func labels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts[i] = fmt.Sprintf("%s=\"%s\"", name, value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if metrics == nil {
+		return
+	}
+	metrics.writeTo(w)
+}
+
+// serveMetrics serves /metrics on addr in the background, for --metrics-addr.
+func serveMetrics(addr string) error {
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+metricsPath, handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Warning: metrics server stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKagiMetrics(t *testing.T) {
+	m := newKagiMetrics()
+	resp := createTestResponse()
+	resp.Meta.MS = 1500
+
+	m.observeAPIRequest(outcomeSuccess, 200, 3*time.Second, resp)
+	m.observeAPIRequest(outcomeAPIError, 429, 100*time.Millisecond, nil)
+	m.observeAPIRequest(outcomeTimeout, 0, 30*time.Second, nil)
+	m.observeCache("hit")
+	m.observeHTTPRequest("/healthz", 200)
+
+	var out strings.Builder
+	m.writeTo(&out)
+	result := out.String()
+
+	expected := []string{
+		"# TYPE kagi_api_requests_total counter",
+		`kagi_api_requests_total{outcome="success",status="200"} 1`,
+		`kagi_api_requests_total{outcome="api_error",status="429"} 1`,
+		`kagi_api_requests_total{outcome="timeout",status="none"} 1`,
+		"# TYPE kagi_api_request_duration_seconds histogram",
+		`kagi_api_request_duration_seconds_bucket{le="0.25"} 1`,
+		`kagi_api_request_duration_seconds_bucket{le="4"} 2`,
+		`kagi_api_request_duration_seconds_bucket{le="+Inf"} 3`,
+		"kagi_api_request_duration_seconds_sum 33.1",
+		"kagi_api_request_duration_seconds_count 3",
+		`kagi_api_reported_duration_seconds_bucket{le="1"} 0`,
+		`kagi_api_reported_duration_seconds_bucket{le="2"} 1`,
+		"kagi_api_reported_duration_seconds_count 1",
+		"kagi_api_tokens_total 50",
+		`kagi_cache_requests_total{result="hit"} 1`,
+		`kagi_cache_requests_total{result="miss"} 0`,
+		`kagi_http_requests_total{path="/healthz",status="200"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(result, line+"\n") {
+			t.Errorf("Metrics missing line %q\n%s", line, result)
+		}
+	}
+}
+
+func TestNilMetricsAreNoOps(t *testing.T) {
+	var m *kagiMetrics
+	m.observeAPIRequest(outcomeSuccess, 200, time.Second, createTestResponse())
+	m.observeCache("hit")
+	m.observeHTTPRequest("/", 200)
+}
+
+func TestLabelsEscaping(t *testing.T) {
+	result := labels([]string{"path"}, []string{"a\"b\\c\nd"})
+	if result != `{path="a\"b\\c\nd"}` {
+		t.Errorf("labels() = %s", result)
+	}
+}
+
+func TestGatewayMetrics(t *testing.T) {
+	previous := metrics
+	metrics = newKagiMetrics()
+	t.Cleanup(func() { metrics = previous })
+
+	gw, _ := newTestGateway(nil, time.Minute, 0)
+	gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+	gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+	gatewayRequest(gw, http.MethodGet, "/nope", "", "")
+
+	rec := gatewayRequest(gw, http.MethodGet, metricsPath, "", "")
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`kagi_cache_requests_total{result="hit"} 1`,
+		`kagi_cache_requests_total{result="miss"} 1`,
+		`kagi_http_requests_total{path="/api/v0/fastgpt",status="200"} 2`,
+		`kagi_http_requests_total{path="other",status="404"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics missing line %q\n%s", line, body)
+		}
+	}
+}
+
+func TestGatewayMetricsRequireToken(t *testing.T) {
+	previous := metrics
+	metrics = newKagiMetrics()
+	t.Cleanup(func() { metrics = previous })
+
+	gw, _ := newTestGateway([]string{"secret"}, 0, 0)
+
+	rec := gatewayRequest(gw, http.MethodGet, metricsPath, "", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Without token: status = %d; want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if strings.Contains(rec.Body.String(), "kagi_") {
+		t.Errorf("Without token: metrics served\n%s", rec.Body)
+	}
+
+	rec = gatewayRequest(gw, http.MethodGet, metricsPath, "", "Bearer wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong token: status = %d; want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = gatewayRequest(gw, http.MethodGet, metricsPath, "", "Bearer secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "kagi_http_requests_total") {
+		t.Errorf("With token: status = %d\n%s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The mock-server command stands in for the FastGPT API in integration
+// tests. It answers with canned responses and can inject each failure that
+// queryKagi handles; point kagi at it with --endpoint or KAGI_ENDPOINT.
+
+const (
+	defaultMockListenAddr = "127.0.0.1:8788"
+
+	// A query of the form fail:<mode> fails that request only
+	mockFailQueryPrefix = "fail:"
+
+	mockFailUnauthorized = "unauthorized"
+	mockFailRateLimit    = "rate-limit"
+	mockFailServerError  = "server-error"
+	mockFailBadGateway   = "bad-gateway"
+	mockFailMalformed    = "malformed"
+	mockFailEmpty        = "empty"
+
+	// mockRetryAfter is the Retry-After header sent with rate-limit failures
+	mockRetryAfter = "60"
+)
+
+// mockFailModes are the failures accepted by --fail and fail:<mode> queries.
+var mockFailModes = []string{
+	mockFailUnauthorized,
+	mockFailRateLimit,
+	mockFailServerError,
+	mockFailBadGateway,
+	mockFailMalformed,
+	mockFailEmpty,
+}
+
+var (
+	flagMockListen     string
+	flagMockAnswer     string
+	flagMockReferences []string
+	flagMockLatency    time.Duration
+	flagMockFail       string
+	flagMockAPIKey     string
+	flagMockVerbose    bool
+)
+
+// mockServer answers FastGPT requests with a canned response.
+type mockServer struct {
+	// answer is the output; empty echoes the query
+	answer     string
+	references []Reference
+	latency    time.Duration
+	// fail is a mock failure mode applied to every request
+	fail string
+	// apiKey, when set, is the only key accepted
+	apiKey   string
+	verbose  bool
+	requests atomic.Int64
+}
+
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server",
+	Short: "Run a mock FastGPT API for integration testing",
+	Long: `Run a mock FastGPT API that answers POST /api/v0/fastgpt with canned responses,
+for testing scripts and tools built on kagi without using API credit.
+
+Point kagi at it with --endpoint or KAGI_ENDPOINT:
+
+  kagi mock-server --answer "Mock answer" --reference "https://example.com|Example" &
+  KAGI_ENDPOINT=http://127.0.0.1:8788/api/v0/fastgpt KAGI_API_KEY=test kagi "any query"
+
+--fail makes every request fail, and a query of the form fail:<mode> fails
+just that request. Modes:
+
+  unauthorized   401 with a FastGPT error body
+  rate-limit     429 with Retry-After
+  server-error   500 with a FastGPT error body
+  bad-gateway    502 with a non-JSON body
+  malformed      200 with invalid JSON
+  empty          200 with an empty output`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fail := strings.ToLower(strings.TrimSpace(flagMockFail))
+		if fail != "" && !isMockFailMode(fail) {
+			return fmt.Errorf("invalid value %q for --fail\nValid values: %s", flagMockFail, strings.Join(mockFailModes, ", "))
+		}
+		if flagMockLatency < 0 {
+			return fmt.Errorf("invalid value %q for --latency\nLatency must not be negative", flagMockLatency)
+		}
+		references, err := parseMockReferences(flagMockReferences)
+		if err != nil {
+			return err
+		}
+
+		mock := &mockServer{
+			answer:     flagMockAnswer,
+			references: references,
+			latency:    flagMockLatency,
+			fail:       fail,
+			apiKey:     flagMockAPIKey,
+			verbose:    flagMockVerbose,
+		}
+
+		listener, err := listen(flagMockListen)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+
+		fmt.Fprintf(os.Stderr, "Mock FastGPT API listening on %s\n", mockEndpoint(flagMockListen, listener.Addr()))
+		return mock.serve(cmd.Context(), listener)
+	},
+}
+
+func init() {
+	mockServerCmd.Flags().StringVar(&flagMockListen, "listen", defaultMockListenAddr, "Address to listen on: host:port or unix:/path/to/socket")
+	mockServerCmd.Flags().StringVar(&flagMockAnswer, "answer", "", "Answer to return (default echoes the query)")
+	mockServerCmd.Flags().StringArrayVar(&flagMockReferences, "reference", nil, "Reference to return as url|title|snippet (repeatable)")
+	mockServerCmd.Flags().DurationVar(&flagMockLatency, "latency", 0, "Delay before each response, e.g. 2s")
+	mockServerCmd.Flags().StringVar(&flagMockFail, "fail", "", "Fail every request: "+strings.Join(mockFailModes, " | "))
+	mockServerCmd.Flags().StringVar(&flagMockAPIKey, "api-key", "", "Only accept this API key (default accepts any)")
+	mockServerCmd.Flags().BoolVar(&flagMockVerbose, "verbose", false, "Log each request to stderr")
+	mockServerCmd.RegisterFlagCompletionFunc("fail", cobra.FixedCompletions(mockFailModes, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.AddCommand(mockServerCmd)
+}
+
+// mockEndpoint describes how to reach the mock API. kagi cannot use a unix
+// socket as its endpoint, so for those it shows the curl invocation.
+func mockEndpoint(listen string, addr net.Addr) string {
+	if path, ok := strings.CutPrefix(listen, unixListenPrefix); ok {
+		return fmt.Sprintf("%s (curl --unix-socket %s http://localhost%s)", listen, path, gatewayFastGPTPath)
+	}
+	return "http://" + addr.String() + gatewayFastGPTPath
+}
+
+// serve answers requests on listener until ctx is cancelled. Requests still
+// waiting out --latency are cancelled too, so shutdown is immediate.
+func (m *mockServer) serve(ctx context.Context, listener net.Listener) error {
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	server := &http.Server{
+		Handler:           m.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return requestCtx },
+	}
+	shutdownErr := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		cancelRequests()
+		shutdownErr <- server.Shutdown(context.Background())
+	})
+	defer stop()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	// Serve returns as soon as shutdown starts; wait for it to finish
+	return <-shutdownErr
+}
+
+func isMockFailMode(mode string) bool {
+	for _, valid := range mockFailModes {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMockReferences parses url|title|snippet values; title and snippet
+// are optional.
+func parseMockReferences(values []string) ([]Reference, error) {
+	references := []Reference{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "|", 3)
+		ref := Reference{URL: strings.TrimSpace(parts[0])}
+		if ref.URL == "" {
+			return nil, fmt.Errorf("invalid value %q for --reference\nExample: --reference \"https://example.com|Example|A snippet\"", value)
+		}
+		if len(parts) > 1 {
+			ref.Title = strings.TrimSpace(parts[1])
+		}
+		if len(parts) > 2 {
+			ref.Snippet = strings.TrimSpace(parts[2])
+		}
+		references = append(references, ref)
+	}
+	return references, nil
+}
+
+func (m *mockServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+gatewayHealthPath, func(w http.ResponseWriter, r *http.Request) {
+		writeGatewayJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST "+gatewayFastGPTPath, m.handleFastGPT)
+	return mux
+}
+
+func (m *mockServer) handleFastGPT(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		if m.verbose {
+			fmt.Fprintf(os.Stderr, "%s %s %s %d %dms\n", time.Now().Format(time.RFC3339), r.Method, r.URL.Path, recorder.status, time.Since(start).Milliseconds())
+		}
+	}()
+	w = recorder
+
+	apiKey, hasKey := strings.CutPrefix(r.Header.Get("Authorization"), authHeaderPrefix)
+	if !hasKey || apiKey == "" || (m.apiKey != "" && apiKey != m.apiKey) {
+		writeGatewayError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	var req FastGPTRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxGatewayBody)).Decode(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		writeGatewayError(w, http.StatusBadRequest, `request body must be JSON with a non-empty "query"`)
+		return
+	}
+
+	if m.latency > 0 {
+		select {
+		case <-time.After(m.latency):
+		case <-r.Context().Done():
+			writeGatewayError(w, http.StatusServiceUnavailable, "mock server is shutting down")
+			return
+		}
+	}
+
+	fail := m.fail
+	if mode, ok := strings.CutPrefix(req.Query, mockFailQueryPrefix); ok {
+		fail = strings.TrimSpace(mode)
+		if !isMockFailMode(fail) {
+			writeGatewayError(w, http.StatusBadRequest, fmt.Sprintf("unknown mock failure %q, valid: %s", fail, strings.Join(mockFailModes, ", ")))
+			return
+		}
+	}
+
+	switch fail {
+	case mockFailUnauthorized:
+		writeGatewayError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	case mockFailRateLimit:
+		w.Header().Set("Retry-After", mockRetryAfter)
+		writeGatewayError(w, http.StatusTooManyRequests, "Too many requests")
+		return
+	case mockFailServerError:
+		writeGatewayError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	case mockFailBadGateway:
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "<html><body>502 Bad Gateway</body></html>\n")
+		return
+	case mockFailMalformed:
+		w.Header().Set("Content-Type", contentTypeJSON)
+		io.WriteString(w, `{"meta":{"id":"mock"},"data":{"output":`)
+		return
+	}
+
+	var resp FastGPTResponse
+	resp.Meta.ID = fmt.Sprintf("mock-%d", m.requests.Add(1))
+	resp.Meta.Node = "mock"
+	resp.Meta.MS = int(time.Since(start).Milliseconds())
+	if fail != mockFailEmpty {
+		resp.Data.Output = m.answer
+		if resp.Data.Output == "" {
+			resp.Data.Output = "Mock answer to: " + req.Query
+		}
+		resp.Data.References = m.references
+	}
+	resp.Data.Tokens = len(strings.Fields(resp.Data.Output))
+	if resp.Data.References == nil {
+		resp.Data.References = []Reference{}
+	}
+	writeGatewayJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMockServer starts mock and points queryKagi at it for the test.
+func useMockServer(t *testing.T, mock *mockServer) {
+	t.Helper()
+	server := httptest.NewServer(mock.handler())
+	t.Cleanup(server.Close)
+
+	previous := apiURL
+	apiURL = server.URL + gatewayFastGPTPath
+	t.Cleanup(func() { apiURL = previous })
+	useHTTPClient(t, server.Client())
+}
+
+func TestMockServerAnswers(t *testing.T) {
+	useMockServer(t, &mockServer{
+		references: []Reference{{URL: "https://example.com", Title: "Example", Snippet: "A snippet"}},
+	})
+
+	resp, err := queryKagi(context.Background(), "any-key", "golang", defaultTimeout)
+	if err != nil {
+		t.Fatalf("queryKagi failed: %v", err)
+	}
+	if resp.Data.Output != "Mock answer to: golang" {
+		t.Errorf("Output = %q; want the echoed query", resp.Data.Output)
+	}
+	if len(resp.Data.References) != 1 || resp.Data.References[0].Title != "Example" {
+		t.Errorf("References = %+v", resp.Data.References)
+	}
+	if resp.Meta.ID != "mock-1" || resp.Data.Tokens != 4 {
+		t.Errorf("Meta = %+v, tokens = %d", resp.Meta, resp.Data.Tokens)
+	}
+	if len(resp.UnknownFields) != 0 {
+		t.Errorf("Mock responses should match the modelled API shape: %v", resp.UnknownFields)
+	}
+}
+
+func TestMockServerFailures(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"fail:unauthorized", "API request failed [401]: Invalid API key"},
+		{"fail:rate-limit", "API rate limit exceeded"},
+		{"fail:server-error", "API request failed [500]: Internal server error"},
+		{"fail:bad-gateway", "API returned HTTP 502"},
+		{"fail:malformed", "failed to parse API response"},
+		{"fail:empty", "API returned empty response"},
+		{"fail:nope", "unknown mock failure"},
+	}
+
+	useMockServer(t, &mockServer{answer: "unused"})
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := queryKagi(context.Background(), "any-key", tt.query, defaultTimeout)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("queryKagi() error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMockServerFailFlag(t *testing.T) {
+	mock := &mockServer{fail: mockFailRateLimit}
+	req := httptest.NewRequest(http.MethodPost, gatewayFastGPTPath, strings.NewReader(`{"query":"golang"}`))
+	req.Header.Set("Authorization", authHeaderPrefix+"key")
+	rec := httptest.NewRecorder()
+	mock.handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != mockRetryAfter {
+		t.Errorf("status = %d, Retry-After = %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMockServerAPIKey(t *testing.T) {
+	useMockServer(t, &mockServer{apiKey: "expected"})
+
+	if _, err := queryKagi(context.Background(), "wrong", "golang", defaultTimeout); err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("Expected invalid key error, got %v", err)
+	}
+	if _, err := queryKagi(context.Background(), "expected", "golang", defaultTimeout); err != nil {
+		t.Errorf("Expected key should be accepted: %v", err)
+	}
+}
+
+func TestMockServerLatency(t *testing.T) {
+	useMockServer(t, &mockServer{latency: 1500 * time.Millisecond})
+
+	_, err := queryKagi(context.Background(), "any-key", "golang", 1)
+	if err == nil || !strings.Contains(err.Error(), "request timeout exceeded (1s)") {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+}
+
+func TestMockServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockServer{latency: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- mock.serve(ctx, listener) }()
+
+	status := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+listener.Addr().String()+gatewayFastGPTPath, strings.NewReader(`{"query":"golang"}`))
+		req.Header.Set("Authorization", authHeaderPrefix+"any-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	// Let the request reach the latency wait before shutting down
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown waited for the in-flight request's latency")
+	}
+	if got := <-status; got != http.StatusServiceUnavailable {
+		t.Errorf("In-flight request status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMockEndpoint(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8788}
+	if got, want := mockEndpoint("localhost:8788", addr), "http://127.0.0.1:8788/api/v0/fastgpt"; got != want {
+		t.Errorf("TCP endpoint = %q, want %q", got, want)
+	}
+	want := "unix:/tmp/kagi.sock (curl --unix-socket /tmp/kagi.sock http://localhost/api/v0/fastgpt)"
+	if got := mockEndpoint("unix:/tmp/kagi.sock", nil); got != want {
+		t.Errorf("Unix endpoint = %q, want %q", got, want)
+	}
+}
+
+func TestParseMockReferences(t *testing.T) {
+	refs, err := parseMockReferences([]string{"https://a.example", "https://b.example|B", "https://c.example|C|Snippet | with bar"})
+	if err != nil {
+		t.Fatalf("parseMockReferences failed: %v", err)
+	}
+	if len(refs) != 3 || refs[1].Title != "B" || refs[2].Snippet != "Snippet | with bar" {
+		t.Errorf("refs = %+v", refs)
+	}
+
+	if _, err := parseMockReferences([]string{"|title"}); err == nil {
+		t.Errorf("Expected error for reference without URL")
+	}
+}
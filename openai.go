@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI-compatible chat completions for the serve gateway. The last user
+// message becomes the FastGPT query; earlier turns are folded into it as
+// context, since FastGPT has no notion of a conversation.
+
+const (
+	openAIChatPath   = "/v1/chat/completions"
+	openAIModelsPath = "/v1/models"
+	openAIModel      = "kagi-fastgpt"
+
+	// How references are returned: appended to the answer text, or only in
+	// the "references" extension field
+	openAIReferencesAppend = "append"
+	openAIReferencesField  = "field"
+
+	// maxFoldedHistory bounds the earlier turns folded into the query; the
+	// most recent turns are kept
+	maxFoldedHistory = 4000
+
+	// openAIStreamChunk is the approximate size of simulated stream chunks
+	openAIStreamChunk = 64
+)
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+type openAIMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type openAIChatResponse struct {
+	ID         string         `json:"id"`
+	Object     string         `json:"object"`
+	Created    int64          `json:"created"`
+	Model      string         `json:"model"`
+	Choices    []openAIChoice `json:"choices"`
+	Usage      *openAIUsage   `json:"usage,omitempty"`
+	References []Reference    `json:"references,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                `json:"index"`
+	Message      *openAIReplyFields `json:"message,omitempty"`
+	Delta        *openAIReplyFields `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type openAIReplyFields struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (gw *gateway) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if !gw.authorized(r) {
+		writeOpenAIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	writeGatewayJSON(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data": []map[string]any{
+			{"id": openAIModel, "object": "model", "created": 0, "owned_by": "kagi"},
+		},
+	})
+}
+
+func (gw *gateway) handleOpenAIChat(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// Log every response, including requests rejected before the cache
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	cache := "-"
+	defer func() { gw.logRequest(r, recorder.status, cache, start) }()
+	w = recorder
+
+	if !gw.authorized(r) {
+		writeOpenAIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	var req openAIChatRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxGatewayBody)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	query, err := openAIQuery(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var body []byte
+	body, cache, err = gw.fetch(r.Context(), query, true)
+	if err != nil {
+		var gwErr *gatewayError
+		errors.As(err, &gwErr)
+		if gwErr.retryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(gwErr.retryAfter))
+		}
+		writeOpenAIError(w, gwErr.status, gwErr.message)
+		return
+	}
+
+	var resp FastGPTResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, "failed to parse API response: "+err.Error())
+		return
+	}
+
+	model := req.Model
+	if model == "" {
+		model = openAIModel
+	}
+	completion := openAIChatResponse{
+		ID:         "chatcmpl-" + resp.Meta.ID,
+		Created:    time.Now().Unix(),
+		Model:      model,
+		References: resp.Data.References,
+	}
+	content := openAIContent(&resp, gw.openAIReferences)
+
+	w.Header().Set("X-Kagi-Cache", cache)
+	if req.Stream {
+		writeOpenAIStream(w, completion, content)
+	} else {
+		stop := "stop"
+		completion.Object = "chat.completion"
+		completion.Choices = []openAIChoice{{
+			Message:      &openAIReplyFields{Role: "assistant", Content: content},
+			FinishReason: &stop,
+		}}
+		completion.Usage = &openAIUsage{
+			CompletionTokens: resp.Data.Tokens,
+			TotalTokens:      resp.Data.Tokens,
+		}
+		writeGatewayJSON(w, http.StatusOK, completion)
+	}
+}
+
+// openAIQuery builds the FastGPT query from the conversation: the last user
+// message, preceded by earlier turns when there are any.
+func openAIQuery(messages []openAIMessage) (string, error) {
+	last := -1
+	for i, message := range messages {
+		if message.Role == "user" {
+			last = i
+		}
+	}
+	if last == -1 {
+		return "", errors.New("messages must include a user message")
+	}
+
+	question := strings.TrimSpace(messageText(messages[last].Content))
+	if question == "" {
+		return "", errors.New("the last user message is empty")
+	}
+
+	var turns []string
+	for _, message := range messages[:last] {
+		if text := strings.TrimSpace(messageText(message.Content)); text != "" {
+			turns = append(turns, roleLabel(message.Role)+": "+text)
+		}
+	}
+	if len(turns) == 0 {
+		return question, nil
+	}
+
+	history := strings.Join(turns, "\n")
+	if runes := []rune(history); len(runes) > maxFoldedHistory {
+		history = "..." + string(runes[len(runes)-maxFoldedHistory:])
+	}
+
+	return "Conversation so far:\n" + history + "\n\nQuestion: " + question, nil
+}
+
+// messageText returns the text of a message, whose content is either a
+// string or a list of parts; non-text parts are ignored.
+func messageText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func roleLabel(role string) string {
+	switch role {
+	case "assistant":
+		return "Assistant"
+	case "system", "developer":
+		return "Instructions"
+	default:
+		return "User"
+	}
+}
+
+// openAIContent is the assistant message: the answer, with the references
+// appended as a markdown list unless they are returned only in the field.
+func openAIContent(resp *FastGPTResponse, references string) string {
+	if references == openAIReferencesField || len(resp.Data.References) == 0 {
+		return resp.Data.Output
+	}
+
+	var content strings.Builder
+	content.WriteString(resp.Data.Output)
+	content.WriteString("\n\nReferences:\n")
+	for i, ref := range resp.Data.References {
+		content.WriteString(fmt.Sprintf("%d. [%s](%s)\n", i+1, ref.Title, ref.URL))
+	}
+	return strings.TrimRight(content.String(), "\n")
+}
+
+// writeOpenAIStream sends the complete answer as a server-sent event stream
+// of chat.completion.chunk objects, as streaming clients expect. FastGPT does
+// not stream, so the chunks are simulated.
+func writeOpenAIStream(w http.ResponseWriter, completion openAIChatResponse, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	completion.Object = "chat.completion.chunk"
+	references := completion.References
+	completion.References = nil
+
+	send := func(delta openAIReplyFields, finishReason *string) {
+		chunk := completion
+		chunk.Choices = []openAIChoice{{Delta: &delta, FinishReason: finishReason}}
+		if finishReason != nil {
+			chunk.References = references
+		}
+		jsonBytes, err := json.Marshal(chunk)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", jsonBytes)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(openAIReplyFields{Role: "assistant"}, nil)
+	for _, piece := range streamPieces(content, openAIStreamChunk) {
+		send(openAIReplyFields{Content: piece}, nil)
+	}
+	stop := "stop"
+	send(openAIReplyFields{}, &stop)
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// streamPieces splits text after whitespace into pieces of about size runes,
+// so that joining the pieces gives back text exactly.
+func streamPieces(text string, size int) []string {
+	var pieces []string
+	runes := []rune(text)
+	for len(runes) > 0 {
+		end := min(size, len(runes))
+		for end < len(runes) && runes[end-1] != ' ' && runes[end-1] != '\n' && end < size*2 {
+			end++
+		}
+		pieces = append(pieces, string(runes[:end]))
+		runes = runes[end:]
+	}
+	return pieces
+}
+
+// writeOpenAIError responds with an error shaped like the OpenAI API's.
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errorType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	}
+	writeGatewayJSON(w, status, map[string]any{
+		"error": map[string]any{"message": message, "type": errorType},
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "single user message",
+			messages: `[{"role":"user","content":"golang generics"}]`,
+			expected: "golang generics",
+		},
+		{
+			name:     "content parts",
+			messages: `[{"role":"user","content":[{"type":"text","text":"what is"},{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"rust"}]}]`,
+			expected: "what is\nrust",
+		},
+		{
+			name:     "prior turns are folded",
+			messages: `[{"role":"system","content":"Be brief"},{"role":"user","content":"golang"},{"role":"assistant","content":"A language."},{"role":"user","content":"who made it?"}]`,
+			expected: "Conversation so far:\nInstructions: Be brief\nUser: golang\nAssistant: A language.\n\nQuestion: who made it?",
+		},
+		{
+			name:     "no user message",
+			messages: `[{"role":"system","content":"Be brief"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "empty user message",
+			messages: `[{"role":"user","content":"  "}]`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []openAIMessage
+			if err := json.Unmarshal([]byte(tt.messages), &messages); err != nil {
+				t.Fatal(err)
+			}
+			result, err := openAIQuery(messages)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openAIQuery failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("openAIQuery() = %q; want %q", result, tt.expected)
+			}
+		})
+	}
+
+	t.Run("long history keeps recent turns", func(t *testing.T) {
+		messages := []openAIMessage{
+			{Role: "user", Content: json.RawMessage(`"first turn"`)},
+			{Role: "assistant", Content: json.RawMessage(`"` + strings.Repeat("x", maxFoldedHistory) + `"`)},
+			{Role: "user", Content: json.RawMessage(`"question"`)},
+		}
+		result, err := openAIQuery(messages)
+		if err != nil {
+			t.Fatalf("openAIQuery failed: %v", err)
+		}
+		if strings.Contains(result, "first turn") || !strings.HasSuffix(result, "Question: question") {
+			t.Errorf("Expected oldest turn dropped and question kept: %q", result[:80])
+		}
+	})
+}
+
+func TestOpenAIContent(t *testing.T) {
+	resp := createTestResponse()
+
+	appended := openAIContent(resp, openAIReferencesAppend)
+	if !strings.HasPrefix(appended, "This is a test response\n\nReferences:\n1. [Test Reference 1](https://example.com/1)") {
+		t.Errorf("Unexpected appended content: %q", appended)
+	}
+
+	if field := openAIContent(resp, openAIReferencesField); field != "This is a test response" {
+		t.Errorf("Field mode content = %q; want answer only", field)
+	}
+}
+
+func TestStreamPieces(t *testing.T) {
+	text := strings.Repeat("word ", 50) + "end 【1】"
+	pieces := streamPieces(text, 16)
+	if len(pieces) < 2 {
+		t.Fatalf("Expected several pieces, got %d", len(pieces))
+	}
+	if strings.Join(pieces, "") != text {
+		t.Errorf("Pieces do not rejoin to the original text")
+	}
+	for _, piece := range pieces[:len(pieces)-1] {
+		if !strings.HasSuffix(piece, " ") {
+			t.Errorf("Piece %q should end at whitespace", piece)
+		}
+	}
+}
+
+func TestOpenAIChatEndpoint(t *testing.T) {
+	t.Run("non-streaming", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"model":"gpt-4","messages":[{"role":"user","content":"golang"}]}`, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+		}
+
+		var resp openAIChatResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		if resp.Object != "chat.completion" || resp.Model != "gpt-4" {
+			t.Errorf("object/model = %q/%q", resp.Object, resp.Model)
+		}
+		if len(resp.Choices) != 1 || resp.Choices[0].Message.Role != "assistant" || *resp.Choices[0].FinishReason != "stop" {
+			t.Fatalf("Unexpected choices: %s", rec.Body)
+		}
+		if !strings.Contains(resp.Choices[0].Message.Content, "answer to golang") {
+			t.Errorf("Content = %q", resp.Choices[0].Message.Content)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"stream":true,"messages":[{"role":"user","content":"golang"}]}`, "")
+		if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+		if events[len(events)-1] != "data: [DONE]" {
+			t.Fatalf("Stream should end with [DONE]: %q", events[len(events)-1])
+		}
+
+		var content strings.Builder
+		var last openAIChatResponse
+		for _, event := range events[:len(events)-1] {
+			var chunk openAIChatResponse
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+				t.Fatalf("Invalid chunk %q: %v", event, err)
+			}
+			if chunk.Object != "chat.completion.chunk" {
+				t.Errorf("object = %q", chunk.Object)
+			}
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			last = chunk
+		}
+		if !strings.Contains(content.String(), "answer to golang") {
+			t.Errorf("Streamed content = %q", content.String())
+		}
+		if last.Choices[0].FinishReason == nil || *last.Choices[0].FinishReason != "stop" {
+			t.Errorf("Last chunk should have finish_reason stop")
+		}
+	})
+
+	t.Run("errors use OpenAI shape", func(t *testing.T) {
+		gw, _ := newTestGateway([]string{"secret"}, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"messages":[]}`, "")
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"type":"authentication_error"`) {
+			t.Errorf("Unauthorized = %d %s", rec.Code, rec.Body)
+		}
+
+		rec = gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"messages":[]}`, "Bearer secret")
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"type":"invalid_request_error"`) {
+			t.Errorf("Bad request = %d %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("every response is logged", func(t *testing.T) {
+		gw, _ := newTestGateway([]string{"secret"}, 0, 0)
+		gw.verbose = true
+		gw.query = func(ctx context.Context, apiKey, query string, timeout int) (*FastGPTResponse, error) {
+			return &FastGPTResponse{Raw: []byte(`{"data":`)}, nil
+		}
+
+		logged := captureStderr(t, func() {
+			gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"messages":[]}`, "")
+			gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"messages":[]}`, "Bearer secret")
+			gatewayRequest(gw, http.MethodPost, openAIChatPath, `{"messages":[{"role":"user","content":"golang"}]}`, "Bearer secret")
+		})
+		for _, want := range []string{
+			openAIChatPath + " 401 cache=- ",
+			openAIChatPath + " 400 cache=- ",
+			openAIChatPath + " 502 cache=miss ",
+		} {
+			if !strings.Contains(logged, want) {
+				t.Errorf("Log missing %q:\n%s", want, logged)
+			}
+		}
+	})
+
+	t.Run("models", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodGet, openAIModelsPath, "", "")
+		if !strings.Contains(rec.Body.String(), openAIModel) {
+			t.Errorf("Models should list %s: %s", openAIModel, rec.Body)
+		}
+	})
+}
+
+// captureStderr returns what fn writes to os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = previous }()
+
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(r)
+		output <- string(content)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
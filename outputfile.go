@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// outputAuto derives the output file name from the query
+	outputAuto = "auto"
+
+	// maxSlugLength keeps derived file names a reasonable length
+	maxSlugLength = 60
+)
+
+// formatExtensions maps output file extensions to formats.
+var formatExtensions = map[string]string{
+	".txt":      formatText,
+	".text":     formatText,
+	".md":       formatMarkdown,
+	".markdown": formatMarkdown,
+	".json":     formatJSON,
+	".jsonl":    formatJSONL,
+	".html":     formatHTML,
+	".htm":      formatHTML,
+	".org":      formatOrg,
+	".adoc":     formatAsciiDoc,
+	".asciidoc": formatAsciiDoc,
+	".rst":      formatRST,
+}
+
+// formatForPath returns the format implied by the file extension, or "" if
+// the extension is not recognised.
+func formatForPath(path string) string {
+	return formatExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// extensionForFormat returns the file extension used for a format by -o auto.
+func extensionForFormat(format string) string {
+	switch format {
+	case formatMarkdown:
+		return ".md"
+	case formatJSON:
+		return ".json"
+	case formatJSONL, formatSlackBlocks:
+		return ".jsonl"
+	case formatHTML:
+		return ".html"
+	case formatOrg:
+		return ".org"
+	case formatAsciiDoc:
+		return ".adoc"
+	case formatRST:
+		return ".rst"
+	default:
+		return ".txt"
+	}
+}
+
+// slugify turns a query into a file name: lowercase words joined by hyphens.
+func slugify(query string) string {
+	var slug strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(query) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			hyphen = false
+			slug.WriteRune(r)
+		} else {
+			hyphen = true
+		}
+	}
+
+	result := []rune(slug.String())
+	if len(result) > maxSlugLength {
+		result = []rune(strings.TrimRight(string(result[:maxSlugLength]), "-"))
+	}
+	if len(result) == 0 {
+		return "kagi-answer"
+	}
+	return string(result)
+}
+
+// checkOverwrite returns an error if path exists and force is not set.
+// It lets kagi fail before asking the question; writeFileAtomic repeats
+// the check when it creates the file.
+func checkOverwrite(path string, force bool) error {
+	if force {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return outputExistsError(path)
+	}
+	return nil
+}
+
+// outputExistsError reports an output file that would be overwritten.
+func outputExistsError(path string) error {
+	return fmt.Errorf("output file %q already exists\nUse --force to overwrite it", path)
+}
+
+// writeFileAtomic writes content to a temporary file in the same directory
+// and moves it to path, so readers never see a partially written file.
+// Without force the move is a hard link, which fails if path was created
+// in the meantime; with force the file is replaced and keeps its mode.
+// If ctx is cancelled before the move, path is left untouched.
+func writeFileAtomic(ctx context.Context, path, content string, force bool) error {
+	if err := checkOverwrite(path, force); err != nil {
+		return err
+	}
+
+	// New files get 0666 less the umask, as with shell redirection; a file
+	// being replaced keeps its mode
+	var keepMode os.FileMode
+	if force {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			keepMode = info.Mode().Perm()
+		}
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := createTempFile(dir, "."+filepath.Base(path)+".tmp-", 0o666)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temporary file on any failure below, and after linking
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+		}
+		if !success || !force {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if keepMode != 0 {
+		if err := os.Chmod(tmpPath, keepMode); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if force {
+		if err := os.Rename(tmpPath, path); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	} else if err := os.Link(tmpPath, path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return outputExistsError(path)
+		}
+		// Some file systems have no hard links, so create the file exclusively
+		if err := writeFileExclusive(path, content, 0o666); err != nil {
+			return err
+		}
+	}
+
+	success = true
+	return nil
+}
+
+// createTempFile creates a new file in dir whose name starts with prefix.
+// Unlike os.CreateTemp, which always uses 0600, the file is created with
+// mode, so the umask applies.
+func createTempFile(dir, prefix string, mode os.FileMode) (*os.File, error) {
+	for range 100 {
+		path := filepath.Join(dir, prefix+strconv.FormatUint(rand.Uint64(), 36))
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+		if !errors.Is(err, fs.ErrExist) {
+			return file, err
+		}
+	}
+	return nil, fmt.Errorf("no unused temporary file name in %s", dir)
+}
+
+// writeFileExclusive writes content to path, failing if path already exists.
+func writeFileExclusive(path, content string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if errors.Is(err, fs.ErrExist) {
+		return outputExistsError(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
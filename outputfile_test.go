@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFormatForPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"answer.md", formatMarkdown},
+		{"answer.markdown", formatMarkdown},
+		{"answer.json", formatJSON},
+		{"answer.jsonl", formatJSONL},
+		{"dir/answer.HTML", formatHTML},
+		{"answer.htm", formatHTML},
+		{"answer.txt", formatText},
+		{"answer.org", formatOrg},
+		{"answer.adoc", formatAsciiDoc},
+		{"answer.rst", formatRST},
+		{"answer.pdf", ""},
+		{"answer", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if result := formatForPath(tt.path); result != tt.expected {
+				t.Errorf("formatForPath(%q) = %q; want %q", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtensionForFormat(t *testing.T) {
+	// Every inferred extension must map back to the same format
+	for _, format := range []string{formatText, formatMarkdown, formatJSON, formatJSONL, formatHTML, formatOrg, formatAsciiDoc, formatRST} {
+		t.Run(format, func(t *testing.T) {
+			ext := extensionForFormat(format)
+			if result := formatForPath("answer" + ext); result != format {
+				t.Errorf("extensionForFormat(%q) = %q, which infers %q", format, ext, result)
+			}
+		})
+	}
+
+	if ext := extensionForFormat(formatDiscord); ext != ".txt" {
+		t.Errorf("extensionForFormat(discord) = %q; want .txt", ext)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"words", "golang generics", "golang-generics"},
+		{"punctuation", "What is Go's GC?", "what-is-go-s-gc"},
+		{"leading and trailing", "  --rust vs go--  ", "rust-vs-go"},
+		{"unicode", "café crème", "café-crème"},
+		{"empty", "?!", "kagi-answer"},
+		{"long", strings.Repeat("word ", 20), strings.TrimSuffix(strings.Repeat("word-", 12), "-")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := slugify(tt.query); result != tt.expected {
+				t.Errorf("slugify(%q) = %q; want %q", tt.query, result, tt.expected)
+			}
+			if len([]rune(slugify(tt.query))) > maxSlugLength {
+				t.Errorf("slugify(%q) exceeds %d characters", tt.query, maxSlugLength)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answer.md")
+
+	t.Run("writes new file", func(t *testing.T) {
+		err := withUmask(0o022, func() error {
+			return writeFileAtomic(context.Background(), path, "first\n", false)
+		})
+		if err != nil {
+			t.Fatalf("writeFileAtomic failed: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if string(content) != "first\n" {
+			t.Errorf("Content = %q; want %q", content, "first\n")
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Mode().Perm() != 0o644 {
+			t.Errorf("Mode = %v; want 0644", info.Mode().Perm())
+		}
+	})
+
+	t.Run("refuses to overwrite without force", func(t *testing.T) {
+		err := writeFileAtomic(context.Background(), path, "second\n", false)
+		if err == nil || !strings.Contains(err.Error(), "--force") {
+			t.Errorf("Expected overwrite error mentioning --force, got %v", err)
+		}
+		content, _ := os.ReadFile(path)
+		if string(content) != "first\n" {
+			t.Errorf("File was modified: %q", content)
+		}
+	})
+
+	t.Run("overwrites with force", func(t *testing.T) {
+		if err := writeFileAtomic(context.Background(), path, "second\n", true); err != nil {
+			t.Fatalf("writeFileAtomic failed: %v", err)
+		}
+		content, _ := os.ReadFile(path)
+		if string(content) != "second\n" {
+			t.Errorf("Content = %q; want %q", content, "second\n")
+		}
+	})
+
+	t.Run("overwrite keeps the file mode", func(t *testing.T) {
+		if err := os.Chmod(path, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(context.Background(), path, "third\n", true); err != nil {
+			t.Fatalf("writeFileAtomic failed: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("Mode = %v; want 0600", info.Mode().Perm())
+		}
+	})
+
+	t.Run("leaves no temporary files", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("Expected only the output file, found %d entries", len(entries))
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		if err := writeFileAtomic(context.Background(), filepath.Join(dir, "missing", "a.md"), "x", false); err == nil {
+			t.Errorf("Expected error for missing directory")
+		}
+	})
+}
+
+func TestWriteFileAtomicUmask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no umask on Windows")
+	}
+
+	// A private umask must not be widened, since the file holds the query
+	path := filepath.Join(t.TempDir(), "answer.json")
+	err := withUmask(0o077, func() error {
+		return writeFileAtomic(context.Background(), path, "{}\n", false)
+	})
+	if err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Mode = %v; want 0600", info.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomicNoClobber(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	// A dangling symlink passes the Stat check, like a file created after
+	// it, so the write itself must refuse to replace it
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answer.md")
+	if err := os.Symlink(filepath.Join(dir, "missing"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFileAtomic(context.Background(), path, "content\n", false)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected overwrite error mentioning --force, got %v", err)
+	}
+	if _, err := os.Readlink(path); err != nil {
+		t.Errorf("Symlink was replaced: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the symlink, found %d entries", len(entries))
+	}
+}
+
+func TestWriteFileExclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answer.md")
+
+	if err := writeFileExclusive(path, "first\n", 0o644); err != nil {
+		t.Fatalf("writeFileExclusive failed: %v", err)
+	}
+	err := writeFileExclusive(path, "second\n", 0o644)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected overwrite error mentioning --force, got %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if string(content) != "first\n" {
+		t.Errorf("File was modified: %q", content)
+	}
+}
+
+func TestCheckOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkOverwrite(filepath.Join(dir, "new.txt"), false); err != nil {
+		t.Errorf("checkOverwrite(new) = %v; want nil", err)
+	}
+	if err := checkOverwrite(existing, false); err == nil {
+		t.Errorf("checkOverwrite(existing) = nil; want error")
+	}
+	if err := checkOverwrite(existing, true); err != nil {
+		t.Errorf("checkOverwrite(existing, force) = %v; want nil", err)
+	}
+}
+
+func TestWriteFileAtomicCancelled(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "answer.md")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := writeFileAtomic(ctx, path, "content\n", false); !errors.Is(err, context.Canceled) {
+		t.Errorf("writeFileAtomic() = %v; want context.Canceled", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Cancelled write should leave nothing behind, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// Pager configuration
+	defaultPager = "less -FRX"
+	envPager     = "PAGER"
+	envKagiPager = "KAGI_PAGER"
+
+	// shellCommandNotFound is the shell's exit status when the pager
+	// command does not exist
+	shellCommandNotFound = 127
+)
+
+// writeOutput prints the rendered output to stdout, routing it through a
+// pager when stdout is a terminal and the output is taller than the screen.
+func writeOutput(output string, config *Config) error {
+	return writeOutputTo(os.Stdout, output, config)
+}
+
+// writeOutputTo writes output to w, paging it when w is a terminal.
+func writeOutputTo(w io.Writer, output string, config *Config) error {
+	f, ok := w.(*os.File)
+	if config.NoPager || !ok || !term.IsTerminal(int(f.Fd())) {
+		_, err := io.WriteString(w, output)
+		return err
+	}
+
+	_, height, err := term.GetSize(int(f.Fd()))
+	if err != nil || !exceedsHeight(output, height) {
+		_, err := io.WriteString(w, output)
+		return err
+	}
+
+	pager := pagerCommand()
+	if pager == "" || pager == "cat" {
+		_, err := io.WriteString(w, output)
+		return err
+	}
+
+	if config.Debug {
+		fmt.Fprintf(os.Stderr, "Debug: Pager: %s\n", pager)
+	}
+
+	if err := runPager(pager, output, w); err != nil {
+		// The pager could not be run, so print the output directly instead
+		fmt.Fprintf(os.Stderr, "Warning: pager failed, writing directly: %v\n", err)
+		_, err := io.WriteString(w, output)
+		return err
+	}
+
+	return nil
+}
+
+// exceedsHeight reports whether output needs more lines than the terminal has.
+func exceedsHeight(output string, height int) bool {
+	if height <= 0 {
+		return false
+	}
+	lines := strings.Count(output, "\n")
+	if !strings.HasSuffix(output, "\n") && output != "" {
+		lines++
+	}
+	return lines > height
+}
+
+// pagerCommand resolves the pager using KAGI_PAGER, then PAGER, then the default.
+// An explicitly empty variable disables paging, matching git's behaviour.
+func pagerCommand() string {
+	if pager, ok := os.LookupEnv(envKagiPager); ok {
+		return strings.TrimSpace(pager)
+	}
+	if pager, ok := os.LookupEnv(envPager); ok {
+		return strings.TrimSpace(pager)
+	}
+	return defaultPager
+}
+
+// runPager writes output to the stdin of the pager command and waits for it
+// to exit. It fails only when the pager could not be run: once a pager has
+// shown the output, how it exits is not kagi's business, as with git and
+// man. Quitting the pager before reading all the output is not an error.
+func runPager(pager, output string, stdout io.Writer) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		fields := strings.Fields(pager)
+		cmd = exec.Command(fields[0], fields[1:]...)
+	} else {
+		cmd = exec.Command("sh", "-c", pager)
+	}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if os.Getenv("LV") == "" {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// The pager owns the terminal now, so let it handle Ctrl-C itself
+	done := foregroundChild()
+	defer done()
+
+	// A write error means the pager stopped reading, which is a normal way
+	// to quit it early
+	_, _ = io.WriteString(stdin, output)
+	stdin.Close()
+
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); errors.As(err, &exitErr) && exitErr.ExitCode() == shellCommandNotFound {
+		return fmt.Errorf("%s: command not found", pager)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExceedsHeight(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		height   int
+		expected bool
+	}{
+		{"short output fits", "one\ntwo\n", 24, false},
+		{"output equal to height fits", strings.Repeat("line\n", 24), 24, false},
+		{"output taller than height", strings.Repeat("line\n", 25), 24, true},
+		{"unterminated last line counted", strings.Repeat("line\n", 24) + "tail", 24, true},
+		{"empty output fits", "", 24, false},
+		{"unknown height never pages", strings.Repeat("line\n", 100), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := exceedsHeight(tt.output, tt.height)
+			if result != tt.expected {
+				t.Errorf("exceedsHeight(%d lines, %d) = %v; want %v", strings.Count(tt.output, "\n"), tt.height, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPagerCommand(t *testing.T) {
+	t.Run("default when unset", func(t *testing.T) {
+		t.Setenv(envKagiPager, "")
+		t.Setenv(envPager, "")
+		os.Unsetenv(envKagiPager)
+		os.Unsetenv(envPager)
+
+		if result := pagerCommand(); result != defaultPager {
+			t.Errorf("pagerCommand() = %q; want %q", result, defaultPager)
+		}
+	})
+
+	t.Run("PAGER used when KAGI_PAGER unset", func(t *testing.T) {
+		t.Setenv(envKagiPager, "")
+		os.Unsetenv(envKagiPager)
+		t.Setenv(envPager, "more")
+
+		if result := pagerCommand(); result != "more" {
+			t.Errorf("pagerCommand() = %q; want %q", result, "more")
+		}
+	})
+
+	t.Run("KAGI_PAGER overrides PAGER", func(t *testing.T) {
+		t.Setenv(envKagiPager, "most")
+		t.Setenv(envPager, "more")
+
+		if result := pagerCommand(); result != "most" {
+			t.Errorf("pagerCommand() = %q; want %q", result, "most")
+		}
+	})
+
+	t.Run("empty KAGI_PAGER disables paging", func(t *testing.T) {
+		t.Setenv(envKagiPager, "")
+		t.Setenv(envPager, "more")
+
+		if result := pagerCommand(); result != "" {
+			t.Errorf("pagerCommand() = %q; want empty", result)
+		}
+	})
+}
+
+func TestWriteOutput_NonTTY(t *testing.T) {
+	// A buffer is not a terminal, so output is written as is and never paged
+	t.Setenv(envKagiPager, "exit 1")
+	output := strings.Repeat("line\n", 500)
+
+	var buf bytes.Buffer
+	if err := writeOutputTo(&buf, output, &Config{}); err != nil {
+		t.Fatalf("writeOutputTo returned error: %v", err)
+	}
+	if buf.String() != output {
+		t.Errorf("writeOutputTo wrote %d bytes; want %d", buf.Len(), len(output))
+	}
+}
+
+func TestRunPager(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pager commands run through sh")
+	}
+
+	t.Run("output passed through", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runPager("cat", "hello\n", &buf); err != nil {
+			t.Fatalf("runPager returned error: %v", err)
+		}
+		if buf.String() != "hello\n" {
+			t.Errorf("pager wrote %q; want %q", buf.String(), "hello\n")
+		}
+		// Ctrl-C cancels kagi again once the pager has exited
+		if n := terminalChildren.Load(); n != 0 {
+			t.Errorf("terminalChildren = %d after the pager exited; want 0", n)
+		}
+	})
+
+	t.Run("quitting early is not an error", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runPager("head -n 1", strings.Repeat("line\n", 100000), &buf); err != nil {
+			t.Errorf("runPager returned error: %v", err)
+		}
+	})
+
+	t.Run("exit status after showing the output ignored", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runPager("cat; exit 1", "hello\n", &buf); err != nil {
+			t.Errorf("runPager returned error: %v", err)
+		}
+		if buf.String() != "hello\n" {
+			t.Errorf("pager wrote %q; want %q", buf.String(), "hello\n")
+		}
+	})
+
+	t.Run("missing pager reported", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := runPager("kagi-no-such-pager", "hello\n", &buf); err == nil {
+			t.Error("runPager returned nil for a pager that does not exist")
+		}
+	})
+}
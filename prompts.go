@@ -0,0 +1,467 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/spf13/cobra"
+)
+
+// Prompt templates are named query templates in the prompts directory of
+// the config directory. Leading # lines are a header declaring variables:
+//
+//	# description: Compare two technologies
+//	# required: a, b
+//	# optional: aspect=overall suitability, audience
+//	Compare {{.a}} and {{.b}} for {{.aspect}}.
+//
+// Variables referenced in the body but not declared are required.
+
+const (
+	promptsDirName = "prompts"
+
+	promptDescriptionKey = "description"
+	promptRequiredKey    = "required"
+	promptOptionalKey    = "optional"
+
+	// promptSkeleton is the starting point for kagi prompts edit
+	promptSkeleton = `# description: Explain a topic
+# required: topic
+# optional: level=beginner
+Explain {{.topic}} for a {{.level}}, with a short example.
+`
+)
+
+var (
+	flagPrompt string
+
+	promptNamePattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	promptVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// promptTemplate is a parsed prompt template.
+type promptTemplate struct {
+	Name        string
+	Description string
+	// Variables are the required variables, then the optional ones
+	Variables []promptVariable
+	tmpl      *template.Template
+}
+
+type promptVariable struct {
+	Name     string
+	Default  string
+	Required bool
+}
+
+func (v promptVariable) String() string {
+	if v.Required {
+		return v.Name
+	}
+	return v.Name + "=" + v.Default
+}
+
+var promptsCmd = &cobra.Command{
+	Use:   "prompts",
+	Short: "List, show and edit prompt templates",
+	Long: `Manage prompt templates, the named query templates used with kagi -p.
+
+Prompt templates are Go templates in the prompts directory of the config
+directory, with a header of # lines declaring their variables:
+
+  # description: Compare two technologies
+  # required: a, b
+  # optional: aspect=overall suitability
+  Compare {{.a}} and {{.b}} for {{.aspect}}.
+
+Use one with: kagi -p compare a=postgres b=mysql`,
+	Args: cobra.NoArgs,
+	RunE: runPromptsList,
+}
+
+var promptsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List prompt templates with their variables",
+	Args:  cobra.NoArgs,
+	RunE:  runPromptsList,
+}
+
+var promptsShowCmd = &cobra.Command{
+	Use:               "show <name>",
+	Short:             "Print a prompt template",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completePromptNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := promptPath(args[0])
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("prompt %q not found\nPrompt templates are read from %s", args[0], path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read prompt: %w", err)
+		}
+		_, err = cmd.OutOrStdout().Write(content)
+		return err
+	},
+}
+
+var promptsEditCmd = &cobra.Command{
+	Use:               "edit <name>",
+	Short:             "Create or edit a prompt template in $VISUAL or $EDITOR",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completePromptNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := promptPath(args[0])
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return fmt.Errorf("failed to create prompts directory: %w", err)
+			}
+			if err := os.WriteFile(path, []byte(promptSkeleton), 0o644); err != nil {
+				return fmt.Errorf("failed to create prompt: %w", err)
+			}
+		}
+
+		if err := runEditor(resolveEditor(), path); err != nil {
+			return err
+		}
+
+		// Report mistakes now rather than on the next query
+		if _, err := loadPrompt(args[0]); err != nil {
+			return fmt.Errorf("saved %s, but %w", path, err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	promptsCmd.AddCommand(promptsListCmd, promptsShowCmd, promptsEditCmd)
+	rootCmd.AddCommand(promptsCmd)
+}
+
+func runPromptsList(cmd *cobra.Command, args []string) error {
+	prompts, invalid, err := listPrompts()
+	if err != nil {
+		return err
+	}
+	for _, err := range invalid {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+	}
+	if len(prompts) == 0 && len(invalid) == 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "No prompt templates in %s\nCreate one with: kagi prompts edit <name>\n", filepath.Join(configDir(), promptsDirName))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	for _, prompt := range prompts {
+		var variables []string
+		for _, variable := range prompt.Variables {
+			variables = append(variables, variable.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", prompt.Name, prompt.Description, strings.Join(variables, " "))
+	}
+	return w.Flush()
+}
+
+// promptPath returns the file for the prompt called name.
+func promptPath(name string) (string, error) {
+	if !promptNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid prompt name %q\nNames may contain letters, digits, - and _", name)
+	}
+	return filepath.Join(configDir(), promptsDirName, name+templateExt), nil
+}
+
+func loadPrompt(name string) (*promptTemplate, error) {
+	path, err := promptPath(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("prompt %q not found\nPrompt templates are read from %s", name, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt: %w", err)
+	}
+	return parsePrompt(name, string(content))
+}
+
+// listPrompts loads every prompt template, sorted by name. Templates that
+// fail to load are returned as errors in invalid, so one broken file does not
+// hide the others.
+func listPrompts() (prompts []*promptTemplate, invalid []error, err error) {
+	paths, err := filepath.Glob(filepath.Join(configDir(), promptsDirName, "*"+templateExt))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list prompts: %w", err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		prompt, err := loadPrompt(strings.TrimSuffix(filepath.Base(path), templateExt))
+		if err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, invalid, nil
+}
+
+// parsePrompt parses a prompt template's header and body.
+func parsePrompt(name, content string) (*promptTemplate, error) {
+	prompt := &promptTemplate{Name: name}
+	declared := map[string]bool{}
+	var optional []promptVariable
+
+	lines := strings.Split(content, "\n")
+	bodyStart := 0
+	for bodyStart < len(lines) && strings.HasPrefix(lines[bodyStart], "#") {
+		line := strings.TrimSpace(strings.TrimPrefix(lines[bodyStart], "#"))
+		bodyStart++
+
+		// Other comment lines are free text
+		key, value, _ := strings.Cut(line, ":")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != promptDescriptionKey && key != promptRequiredKey && key != promptOptionalKey {
+			continue
+		}
+		if key == promptDescriptionKey {
+			prompt.Description = strings.TrimSpace(value)
+			continue
+		}
+
+		for _, item := range strings.Split(value, ",") {
+			variableName, defaultValue, _ := strings.Cut(item, "=")
+			variable := promptVariable{
+				Name:     strings.TrimSpace(variableName),
+				Default:  strings.TrimSpace(defaultValue),
+				Required: key == promptRequiredKey,
+			}
+			if variable.Name == "" {
+				continue
+			}
+			if !promptVariablePattern.MatchString(variable.Name) {
+				return nil, fmt.Errorf("invalid prompt %q: invalid variable name %q", name, variable.Name)
+			}
+			if declared[variable.Name] {
+				return nil, fmt.Errorf("invalid prompt %q: variable %q is declared twice", name, variable.Name)
+			}
+			declared[variable.Name] = true
+			if variable.Required {
+				prompt.Variables = append(prompt.Variables, variable)
+			} else {
+				optional = append(optional, variable)
+			}
+		}
+	}
+
+	body := strings.TrimSpace(strings.Join(lines[bodyStart:], "\n"))
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt %q: %w", name, err)
+	}
+	prompt.tmpl = tmpl
+
+	// Undeclared variables the body uses are required
+	referenced := map[string]bool{}
+	if tmpl.Tree != nil {
+		referencedFields(tmpl.Tree.Root, referenced)
+	}
+	var undeclared []string
+	for variable := range referenced {
+		if !declared[variable] {
+			undeclared = append(undeclared, variable)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, variable := range undeclared {
+		prompt.Variables = append(prompt.Variables, promptVariable{Name: variable, Required: true})
+	}
+	prompt.Variables = append(prompt.Variables, optional...)
+
+	return prompt, nil
+}
+
+// referencedFields collects the top-level fields, such as .name, that node
+// uses. Inside range and with the dot changes, so only their pipelines and
+// else branches are searched.
+func referencedFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			referencedFields(child, fields)
+		}
+	case *parse.ActionNode:
+		referencedFields(n.Pipe, fields)
+	case *parse.IfNode:
+		referencedFields(n.Pipe, fields)
+		referencedFields(n.List, fields)
+		referencedFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		referencedFields(n.Pipe, fields)
+		referencedFields(n.ElseList, fields)
+	case *parse.WithNode:
+		referencedFields(n.Pipe, fields)
+		referencedFields(n.ElseList, fields)
+	case *parse.TemplateNode:
+		referencedFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				referencedFields(arg, fields)
+			}
+		}
+	case *parse.ChainNode:
+		referencedFields(n.Node, fields)
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	}
+}
+
+// parsePromptArgs parses name=value arguments.
+func parsePromptArgs(args []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || !promptVariablePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid prompt argument %q\nArguments to -p are name=value, e.g. kagi -p compare a=postgres b=mysql", arg)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// render executes the prompt with values, applying defaults. Every missing
+// required variable is reported at once.
+func (p *promptTemplate) render(values map[string]string) (string, error) {
+	data := map[string]string{}
+	known := map[string]bool{}
+	var missing, usage []string
+	for _, variable := range p.Variables {
+		known[variable.Name] = true
+		value, ok := values[variable.Name]
+		switch {
+		case ok:
+			data[variable.Name] = value
+		case variable.Required:
+			missing = append(missing, variable.Name)
+			usage = append(usage, variable.Name+"=<value>")
+		default:
+			data[variable.Name] = variable.Default
+		}
+	}
+
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		var variables []string
+		for _, variable := range p.Variables {
+			variables = append(variables, variable.String())
+		}
+		return "", fmt.Errorf("unknown variables for prompt %q: %s\nVariables: %s", p.Name, strings.Join(unknown, ", "), strings.Join(variables, " "))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing variables for prompt %q: %s\nUsage: kagi -p %s %s", p.Name, strings.Join(missing, ", "), p.Name, strings.Join(usage, " "))
+	}
+
+	var query strings.Builder
+	if err := p.tmpl.Execute(&query, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %q: %w", p.Name, err)
+	}
+	result := strings.TrimSpace(query.String())
+	if result == "" {
+		return "", fmt.Errorf("prompt %q rendered an empty query", p.Name)
+	}
+	return result, nil
+}
+
+// promptQuery renders the prompt called name with args as its variables.
+func promptQuery(name string, args []string) (string, error) {
+	prompt, err := loadPrompt(name)
+	if err != nil {
+		return "", err
+	}
+	values, err := parsePromptArgs(args)
+	if err != nil {
+		return "", err
+	}
+	return prompt.render(values)
+}
+
+// completePromptNames suggests the prompt templates in the config directory.
+func completePromptNames(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if cmd != rootCmd && len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	paths, _ := filepath.Glob(filepath.Join(configDir(), promptsDirName, "*"+templateExt))
+
+	var names []cobra.Completion
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), templateExt)
+		if !strings.HasPrefix(name, toComplete) {
+			continue
+		}
+		// A broken prompt is still offered, without its description
+		if prompt, err := loadPrompt(name); err == nil && prompt.Description != "" {
+			names = append(names, cobra.CompletionWithDesc(name, prompt.Description))
+		} else {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completePromptArgs suggests name= for the variables of the -p prompt not
+// yet given.
+func completePromptArgs(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if flagPrompt == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	prompt, err := loadPrompt(flagPrompt)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	given := map[string]bool{}
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		given[name] = true
+	}
+
+	var completions []cobra.Completion
+	for _, variable := range prompt.Variables {
+		if given[variable.Name] {
+			continue
+		}
+		description := "required"
+		if !variable.Required {
+			description = "default: " + variable.Default
+		}
+		completions = append(completions, cobra.CompletionWithDesc(variable.Name+"=", description))
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
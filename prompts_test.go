@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePrompts creates prompt templates in a temporary config directory.
+func writePrompts(t *testing.T, prompts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(envConfigDir, dir)
+	promptsDir := filepath.Join(dir, promptsDirName)
+	if err := os.MkdirAll(promptsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range prompts {
+		if err := os.WriteFile(filepath.Join(promptsDir, name+templateExt), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return promptsDir
+}
+
+const comparePrompt = `# description: Compare two technologies
+# required: a, b
+# optional: aspect=overall suitability, audience
+Compare {{.a}} and {{.b}} for {{.aspect}}.{{if .audience}} Audience: {{.audience}}.{{end}}
+`
+
+func TestParsePrompt(t *testing.T) {
+	prompt, err := parsePrompt("compare", comparePrompt)
+	if err != nil {
+		t.Fatalf("parsePrompt failed: %v", err)
+	}
+	if prompt.Description != "Compare two technologies" {
+		t.Errorf("Description = %q", prompt.Description)
+	}
+
+	var variables []string
+	for _, variable := range prompt.Variables {
+		variables = append(variables, variable.String())
+	}
+	if strings.Join(variables, " ") != "a b aspect=overall suitability audience=" {
+		t.Errorf("Variables = %v", variables)
+	}
+}
+
+func TestParsePromptUndeclaredVariables(t *testing.T) {
+	prompt, err := parsePrompt("explain", "# Free comment\nExplain {{.topic}}{{with .lang}} in {{.}}{{end}}{{range .items}}{{.Name}}{{end}}")
+	if err != nil {
+		t.Fatalf("parsePrompt failed: %v", err)
+	}
+
+	var required []string
+	for _, variable := range prompt.Variables {
+		if variable.Required {
+			required = append(required, variable.Name)
+		}
+	}
+	// .Name is inside range, where the dot is an item, not a variable
+	if strings.Join(required, ",") != "items,lang,topic" {
+		t.Errorf("Required = %v; want the undeclared variables the body uses", required)
+	}
+}
+
+func TestParsePromptErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid template", "Explain {{.topic", "invalid prompt"},
+		{"invalid variable name", "# required: my-topic\nExplain", "invalid variable name"},
+		{"declared twice", "# required: topic\n# optional: topic=x\nExplain", "declared twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePrompt("test", tt.content); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePrompt() error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPromptRender(t *testing.T) {
+	prompt, err := parsePrompt("compare", comparePrompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    string
+		wantErr string
+	}{
+		{"defaults", map[string]string{"a": "postgres", "b": "mysql"}, "Compare postgres and mysql for overall suitability.", ""},
+		{"optional values", map[string]string{"a": "go", "b": "rust", "aspect": "CLIs", "audience": "beginners"}, "Compare go and rust for CLIs. Audience: beginners.", ""},
+		{"missing variables listed", map[string]string{}, "", `missing variables for prompt "compare": a, b`},
+		{"unknown variable", map[string]string{"a": "x", "b": "y", "c": "z"}, "", `unknown variables for prompt "compare": c`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prompt.render(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("render() error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("render() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptQuery(t *testing.T) {
+	writePrompts(t, map[string]string{"compare": comparePrompt})
+
+	query, err := promptQuery("compare", []string{"a=postgres", "b=my=sql"})
+	if err != nil {
+		t.Fatalf("promptQuery failed: %v", err)
+	}
+	if query != "Compare postgres and my=sql for overall suitability." {
+		t.Errorf("query = %q", query)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		prompt  string
+		args    []string
+		wantErr string
+	}{
+		{"not found", "missing", nil, "not found"},
+		{"path traversal", "../compare", nil, "invalid prompt name"},
+		{"argument without value", "compare", []string{"a=x", "postgres"}, "name=value"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := promptQuery(tt.prompt, tt.args); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("promptQuery() error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPromptsListCommand(t *testing.T) {
+	writePrompts(t, map[string]string{
+		"compare": comparePrompt,
+		"explain": "Explain {{.topic}}",
+	})
+
+	var out strings.Builder
+	promptsListCmd.SetOut(&out)
+	defer promptsListCmd.SetOut(nil)
+	if err := promptsListCmd.RunE(promptsListCmd, nil); err != nil {
+		t.Fatalf("prompts list failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "compare") || !strings.Contains(lines[0], "Compare two technologies") || !strings.HasSuffix(lines[1], "topic") {
+		t.Errorf("Unexpected list output:\n%s", out.String())
+	}
+}
+
+func TestPromptsListCommand_InvalidPrompt(t *testing.T) {
+	writePrompts(t, map[string]string{
+		"broken":  "Explain {{.topic",
+		"explain": "Explain {{.topic}}",
+	})
+
+	var out, errOut strings.Builder
+	promptsListCmd.SetOut(&out)
+	promptsListCmd.SetErr(&errOut)
+	defer promptsListCmd.SetOut(nil)
+	defer promptsListCmd.SetErr(nil)
+	if err := promptsListCmd.RunE(promptsListCmd, nil); err != nil {
+		t.Fatalf("prompts list failed: %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "explain") || strings.Contains(out.String(), "broken") {
+		t.Errorf("Expected only the valid prompt to be listed, got:\n%s", out.String())
+	}
+	if !strings.Contains(errOut.String(), `Warning: invalid prompt "broken"`) {
+		t.Errorf("Expected a warning for the broken prompt, got: %q", errOut.String())
+	}
+}
+
+func TestCompletePrompts(t *testing.T) {
+	promptsDir := writePrompts(t, map[string]string{
+		"compare": comparePrompt,
+		"broken":  "{{.x",
+	})
+
+	names, _ := completePromptNames(rootCmd, nil, "")
+	if strings.Join(names, ",") != "broken,compare\tCompare two technologies" {
+		t.Errorf("completePromptNames() = %q", names)
+	}
+
+	previous := flagPrompt
+	flagPrompt = "compare"
+	defer func() { flagPrompt = previous }()
+	args, _ := completePromptArgs(rootCmd, []string{"a=postgres"}, "")
+	var suggested []string
+	for _, arg := range args {
+		suggested = append(suggested, strings.SplitN(arg, "\t", 2)[0])
+	}
+	if strings.Join(suggested, ",") != "b=,aspect=,audience=" {
+		t.Errorf("completePromptArgs() = %v; want the variables not yet given", suggested)
+	}
+
+	if _, err := os.Stat(promptsDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// Raw output modes
+	rawExact  = "exact"
+	rawPretty = "pretty"
+)
+
+// formatRaw_output returns the API response body as received. The pretty
+// mode re-indents it without decoding, so every field is preserved.
+func formatRaw_output(resp *FastGPTResponse, config *Config) (string, error) {
+	if config.Raw != rawPretty {
+		return string(resp.Raw), nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, resp.Raw, "", "  "); err != nil {
+		return "", fmt.Errorf("failed to indent raw response: %w", err)
+	}
+	buf.WriteString("\n")
+
+	return buf.String(), nil
+}
+
+// unknownFields returns the paths of fields in body that have no
+// corresponding json tag in t, e.g. "meta.region" or "data.references[].favicon".
+// It lets --debug report when the API adds fields this client drops.
+func unknownFields(body []byte, t reflect.Type) []string {
+	var fields []string
+	collectUnknownFields(json.RawMessage(body), t, "", &fields)
+	sort.Strings(fields)
+	return fields
+}
+
+func collectUnknownFields(raw json.RawMessage, t reflect.Type, prefix string, fields *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(raw, &object) != nil {
+			return
+		}
+
+		known := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			known[name] = field.Type
+		}
+
+		for key, value := range object {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			fieldType, ok := known[key]
+			if !ok {
+				*fields = append(*fields, path)
+				continue
+			}
+			collectUnknownFields(value, fieldType, path, fields)
+		}
+
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return
+		}
+
+		// Report each unknown field once, not once per array element
+		seen := make(map[string]bool)
+		for _, item := range items {
+			var itemFields []string
+			collectUnknownFields(item, t.Elem(), prefix+"[]", &itemFields)
+			for _, field := range itemFields {
+				if !seen[field] {
+					seen[field] = true
+					*fields = append(*fields, field)
+				}
+			}
+		}
+	}
+}
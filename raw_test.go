@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatRaw_output(t *testing.T) {
+	resp := createTestResponse()
+	resp.Raw = []byte(`{"meta":{"id":"x","new_field":1},"data":{"output":"answer"}}`)
+
+	t.Run("exact mode returns bytes unchanged", func(t *testing.T) {
+		result, err := formatRaw_output(resp, &Config{Raw: rawExact})
+		if err != nil {
+			t.Fatalf("formatRaw_output failed: %v", err)
+		}
+		if result != string(resp.Raw) {
+			t.Errorf("formatRaw_output() = %q; want %q", result, resp.Raw)
+		}
+	})
+
+	t.Run("pretty mode preserves unknown fields", func(t *testing.T) {
+		result, err := formatRaw_output(resp, &Config{Raw: rawPretty})
+		if err != nil {
+			t.Fatalf("formatRaw_output failed: %v", err)
+		}
+		if !strings.Contains(result, `"new_field": 1`) {
+			t.Errorf("Pretty output dropped unknown field: %s", result)
+		}
+		if !strings.Contains(result, "\n  \"meta\"") {
+			t.Errorf("Pretty output should be indented: %s", result)
+		}
+	})
+
+	t.Run("pretty mode rejects invalid JSON", func(t *testing.T) {
+		invalid := createTestResponse()
+		invalid.Raw = []byte("not json")
+		if _, err := formatRaw_output(invalid, &Config{Raw: rawPretty}); err == nil {
+			t.Errorf("Expected error for invalid JSON")
+		}
+	})
+}
+
+func TestUnknownFields(t *testing.T) {
+	responseType := reflect.TypeOf(FastGPTResponse{})
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "known fields only",
+			body:     `{"meta":{"id":"1","node":"n","ms":5},"data":{"output":"o","tokens":1,"references":[{"title":"t","snippet":"s","url":"u"}]}}`,
+			expected: nil,
+		},
+		{
+			name:     "unknown top-level and nested fields",
+			body:     `{"meta":{"id":"1","region":"au"},"data":{"output":"o","model":"x"},"warnings":[]}`,
+			expected: []string{"data.model", "meta.region", "warnings"},
+		},
+		{
+			name:     "unknown reference fields reported once",
+			body:     `{"data":{"output":"o","references":[{"title":"a","favicon":"f"},{"title":"b","favicon":"g"}]}}`,
+			expected: []string{"data.references[].favicon"},
+		},
+		{
+			name:     "invalid JSON reports nothing",
+			body:     `not json`,
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := unknownFields([]byte(tt.body), responseType)
+			if len(result) == 0 && len(tt.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("unknownFields() = %v; want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// jsonSchemaVersion is incremented whenever the JSON envelope changes in a
+// way that could break consumers.
+const jsonSchemaVersion = "1"
+
+// JSONEnvelope wraps JSON output with details of the request that produced
+// it, so downstream tools do not need to track them separately.
+type JSONEnvelope struct {
+	SchemaVersion string             `json:"schema_version"`
+	Query         string             `json:"query"`
+	Request       JSONRequestOptions `json:"request"`
+	CLIVersion    string             `json:"cli_version"`
+	Timestamp     string             `json:"timestamp"`
+	Response      any                `json:"response"`
+}
+
+// JSONRequestOptions records the options the request was made with.
+type JSONRequestOptions struct {
+	WebSearch bool `json:"web_search"`
+	Cache     bool `json:"cache"`
+	Timeout   int  `json:"timeout"`
+	Quiet     bool `json:"quiet"`
+	// Files lists the files attached with --file
+	Files []JSONAttachedFile `json:"files,omitempty"`
+}
+
+// JSONAttachedFile describes a file attached to the query.
+type JSONAttachedFile struct {
+	Path      string `json:"path"`
+	Language  string `json:"language,omitempty"`
+	Chars     int    `json:"chars"`
+	Truncated bool   `json:"truncated"`
+}
+
+// quietJSONResponse is the response in quiet mode: the answer only.
+type quietJSONResponse struct {
+	Data struct {
+		Output string `json:"output"`
+	} `json:"data"`
+}
+
+func newJSONEnvelope(resp *FastGPTResponse, config *Config) JSONEnvelope {
+	envelope := JSONEnvelope{
+		SchemaVersion: jsonSchemaVersion,
+		Query:         config.Question,
+		Request: JSONRequestOptions{
+			WebSearch: webSearchEnabled,
+			Cache:     cacheEnabled,
+			Timeout:   config.Timeout,
+			Quiet:     config.Quiet,
+		},
+		CLIVersion: version,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Response:   resp,
+	}
+	for _, file := range config.Files {
+		envelope.Request.Files = append(envelope.Request.Files, JSONAttachedFile{
+			Path:      file.Path,
+			Language:  file.Language,
+			Chars:     file.Chars,
+			Truncated: file.Truncated,
+		})
+	}
+
+	if config.Quiet {
+		var quiet quietJSONResponse
+		quiet.Data.Output = resp.Data.Output
+		envelope.Response = quiet
+	}
+
+	return envelope
+}
+
+// outputJSONSchema describes JSONEnvelope for the json and jsonl formats.
+const outputJSONSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/grantcarthew/kagi/schema/v1.json",
+  "title": "kagi JSON output",
+  "description": "Output of kagi -f json and -f jsonl (one object per line).",
+  "type": "object",
+  "required": ["schema_version", "query", "request", "cli_version", "timestamp", "response"],
+  "properties": {
+    "schema_version": {
+      "description": "Version of this schema. Incremented on breaking changes.",
+      "const": "1"
+    },
+    "query": {
+      "description": "The question asked, without stdin context or attached files.",
+      "type": "string"
+    },
+    "request": {
+      "description": "Options the request was made with.",
+      "type": "object",
+      "required": ["web_search", "cache", "timeout", "quiet"],
+      "properties": {
+        "web_search": { "type": "boolean" },
+        "cache": { "type": "boolean" },
+        "timeout": { "description": "HTTP request timeout in seconds.", "type": "integer", "minimum": 1 },
+        "quiet": { "description": "When true, response contains data.output only.", "type": "boolean" },
+        "files": {
+          "description": "Files attached to the query with --file. Absent when there are none.",
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["path", "chars", "truncated"],
+            "properties": {
+              "path": { "type": "string" },
+              "language": { "type": "string" },
+              "chars": { "description": "Length of the file in characters before truncation.", "type": "integer" },
+              "truncated": { "description": "When true, the middle of the file was cut to fit --file-budget.", "type": "boolean" }
+            }
+          }
+        }
+      }
+    },
+    "cli_version": {
+      "description": "Version of the kagi CLI that produced the output.",
+      "type": "string"
+    },
+    "timestamp": {
+      "description": "Time the output was produced (RFC 3339, UTC).",
+      "type": "string",
+      "format": "date-time"
+    },
+    "response": {
+      "description": "The FastGPT API response.",
+      "type": "object",
+      "required": ["data"],
+      "properties": {
+        "meta": {
+          "description": "Absent in quiet mode.",
+          "type": "object",
+          "properties": {
+            "id": { "type": "string" },
+            "node": { "type": "string" },
+            "ms": { "description": "Processing time reported by Kagi in milliseconds.", "type": "integer" }
+          }
+        },
+        "data": {
+          "type": "object",
+          "required": ["output"],
+          "properties": {
+            "output": { "description": "The answer, in markdown.", "type": "string" },
+            "tokens": { "description": "Absent in quiet mode.", "type": "integer" },
+            "references": {
+              "description": "Absent in quiet mode.",
+              "type": ["array", "null"],
+              "items": {
+                "type": "object",
+                "required": ["title", "snippet", "url"],
+                "properties": {
+                  "title": { "type": "string" },
+                  "snippet": { "type": "string" },
+                  "url": { "type": "string" }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema for json and jsonl output",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := fmt.Fprint(cmd.OutOrStdout(), outputJSONSchema)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJSONEnvelope(t *testing.T) {
+	resp := createTestResponse()
+
+	t.Run("envelope records the request", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatJSON, Timeout: 45}
+
+		envelope := newJSONEnvelope(resp, config)
+
+		if envelope.SchemaVersion != jsonSchemaVersion {
+			t.Errorf("SchemaVersion = %q; want %q", envelope.SchemaVersion, jsonSchemaVersion)
+		}
+		if envelope.Query != "test query" {
+			t.Errorf("Query = %q; want %q", envelope.Query, "test query")
+		}
+		if envelope.Request.Timeout != 45 || !envelope.Request.WebSearch || !envelope.Request.Cache {
+			t.Errorf("Request = %+v; want timeout 45 with web search and cache", envelope.Request)
+		}
+		if envelope.CLIVersion != version {
+			t.Errorf("CLIVersion = %q; want %q", envelope.CLIVersion, version)
+		}
+		if _, err := time.Parse(time.RFC3339, envelope.Timestamp); err != nil {
+			t.Errorf("Timestamp %q is not RFC 3339: %v", envelope.Timestamp, err)
+		}
+		if envelope.Response != resp {
+			t.Errorf("Response should be the full API response")
+		}
+	})
+
+	t.Run("quiet envelope contains output only", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatJSON, Quiet: true}
+
+		envelope := newJSONEnvelope(resp, config)
+
+		quiet, ok := envelope.Response.(quietJSONResponse)
+		if !ok {
+			t.Fatalf("Response type = %T; want quietJSONResponse", envelope.Response)
+		}
+		if quiet.Data.Output != "This is a test response" {
+			t.Errorf("Output = %q; want %q", quiet.Data.Output, "This is a test response")
+		}
+	})
+
+	t.Run("envelope lists attached files", func(t *testing.T) {
+		config := &Config{Question: "test query", Format: formatJSON, Files: []attachment{
+			{Path: "main.go", Language: "go", Content: "package main", Chars: 12},
+		}}
+
+		jsonBytes, err := json.Marshal(newJSONEnvelope(resp, config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(jsonBytes), `"files":[{"path":"main.go","language":"go","chars":12,"truncated":false}]`) {
+			t.Errorf("Envelope should list attached files without their content: %s", jsonBytes)
+		}
+
+		jsonBytes, _ = json.Marshal(newJSONEnvelope(resp, &Config{Question: "test query"}))
+		if strings.Contains(string(jsonBytes), `"files"`) {
+			t.Errorf("files should be omitted without attachments")
+		}
+	})
+}
+
+func TestFormatJSON_output_JSONL(t *testing.T) {
+	resp := createTestResponse()
+	resp.Data.Output = "multi\nline\noutput"
+
+	config := &Config{Question: "test query", Format: formatJSONL}
+
+	result, err := formatJSON_output(resp, config)
+	if err != nil {
+		t.Fatalf("formatJSON_output failed: %v", err)
+	}
+
+	if strings.Count(result, "\n") != 1 || !strings.HasSuffix(result, "\n") {
+		t.Errorf("JSON Lines output should be exactly one line: %q", result)
+	}
+
+	var parsed JSONEnvelope
+	if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Errorf("JSON Lines output is not valid JSON: %v", err)
+	}
+	if parsed.Query != "test query" {
+		t.Errorf("Query = %q; want %q", parsed.Query, "test query")
+	}
+}
+
+func TestOutputJSONSchema(t *testing.T) {
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(outputJSONSchema), &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	// Every envelope field must be described and required by the schema
+	envelopeType := reflect.TypeOf(JSONEnvelope{})
+	for i := 0; i < envelopeType.NumField(); i++ {
+		name := strings.Split(envelopeType.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("Schema missing property %q", name)
+		}
+		found := false
+		for _, required := range schema.Required {
+			if required == name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Schema does not require property %q", name)
+		}
+	}
+
+	if !strings.Contains(string(schema.Properties["schema_version"]), `"`+jsonSchemaVersion+`"`) {
+		t.Errorf("Schema version constant does not match jsonSchemaVersion")
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The serve command is a local HTTP gateway to FastGPT. Clients send
+// FastGPT-shaped requests without the API key; the gateway adds the key,
+// shares a response cache between clients and rate limits upstream calls.
+
+const (
+	defaultListenAddr = "127.0.0.1:8787"
+	unixListenPrefix  = "unix:"
+
+	gatewayFastGPTPath = "/api/v0/fastgpt"
+	gatewayHealthPath  = "/healthz"
+
+	defaultCacheTTL  = 5 * time.Minute
+	maxCacheEntries  = 1000
+	maxGatewayBody   = 1 << 20
+	bearerAuthPrefix = "Bearer "
+)
+
+var (
+	flagListen      string
+	flagTokens      []string
+	flagTokenFile   string
+	flagCacheTTL    time.Duration
+	flagRateLimit   int
+	flagOpenAIRefs  string
+	flagMetricsAddr string
+)
+
+// gateway serves FastGPT requests using the server-side API key. query is
+// queryKagi outside of tests.
+type gateway struct {
+	apiKey  string
+	timeout int
+	query   func(ctx context.Context, apiKey, query string, timeout int) (*FastGPTResponse, error)
+	tokens  [][sha256.Size]byte
+	cache   *responseCache
+	limiter *rateLimiter
+	verbose bool
+
+	// openAIReferences is openAIReferencesAppend or openAIReferencesField
+	openAIReferences string
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a local HTTP gateway to FastGPT",
+	Long: `Run a local HTTP gateway that accepts FastGPT API requests from local clients
+and forwards them using the server's API key, so clients never see the key.
+
+Clients POST {"query": "..."} to /api/v0/fastgpt, or use the OpenAI-compatible
+/v1/chat/completions endpoint. GET /healthz reports status and GET /metrics
+serves Prometheus metrics, requiring a token like the API endpoints.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apiKey, err := resolveAPIKey()
+		if err != nil {
+			return err
+		}
+		if flagTimeout <= 0 {
+			return fmt.Errorf("invalid timeout value %q\nTimeout must be a positive integer (seconds)", fmt.Sprint(flagTimeout))
+		}
+		if err := configureTransport(); err != nil {
+			return err
+		}
+		if flagRateLimit < 0 {
+			return fmt.Errorf("invalid value %q for --rate-limit\nRate limit must be zero (unlimited) or a positive number of requests per minute", fmt.Sprint(flagRateLimit))
+		}
+
+		openAIRefs := strings.ToLower(strings.TrimSpace(flagOpenAIRefs))
+		if openAIRefs != openAIReferencesAppend && openAIRefs != openAIReferencesField {
+			return fmt.Errorf("invalid value %q for --openai-references\nValid values: append, field", flagOpenAIRefs)
+		}
+
+		tokens, err := loadTokens(flagTokens, flagTokenFile)
+		if err != nil {
+			return err
+		}
+
+		gw := newGateway(apiKey, flagTimeout, tokens, flagCacheTTL, flagRateLimit)
+		gw.query = queryKagi
+		gw.verbose = flagVerbose
+		gw.openAIReferences = openAIRefs
+
+		metrics = newKagiMetrics()
+		if flagMetricsAddr != "" {
+			if err := serveMetrics(flagMetricsAddr); err != nil {
+				return err
+			}
+		}
+
+		listener, err := listen(flagListen)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+
+		fmt.Fprintf(os.Stderr, "Listening on %s\n", flagListen)
+		if len(tokens) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: no --token set, any local client can use the gateway\n")
+		}
+
+		server := &http.Server{
+			Handler:           gw.handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		// Stop accepting connections on cancellation and let in-flight
+		// requests finish
+		shutdownErr := make(chan error, 1)
+		stop := context.AfterFunc(cmd.Context(), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(flagTimeout)*time.Second)
+			defer cancel()
+			shutdownErr <- server.Shutdown(ctx)
+		})
+		defer stop()
+
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			return err
+		}
+		// Serve returns as soon as shutdown starts; wait for it to finish
+		if err := <-shutdownErr; err != nil {
+			return fmt.Errorf("in-flight requests did not finish: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&flagAPIKey, "api-key", "", "Kagi API key (overrides KAGI_API_KEY env var)")
+	serveCmd.Flags().IntVarP(&flagTimeout, "timeout", "t", defaultTimeout, "HTTP request timeout in seconds")
+	addTransportFlags(serveCmd)
+	serveCmd.Flags().StringVar(&flagListen, "listen", defaultListenAddr, "Address to listen on: host:port or unix:/path/to/socket")
+	serveCmd.Flags().StringArrayVar(&flagTokens, "token", nil, "Bearer token clients must send (repeatable)")
+	serveCmd.Flags().StringVar(&flagTokenFile, "token-file", "", "File of bearer tokens, one per line")
+	serveCmd.Flags().DurationVar(&flagCacheTTL, "cache-ttl", defaultCacheTTL, "How long to cache answers (0 disables caching)")
+	serveCmd.Flags().IntVar(&flagRateLimit, "rate-limit", 0, "Maximum upstream requests per minute (0 for unlimited)")
+	serveCmd.Flags().StringVar(&flagOpenAIRefs, "openai-references", openAIReferencesAppend, "References in OpenAI chat completions: append | field")
+	serveCmd.Flags().StringVar(&flagMetricsAddr, "metrics-addr", "", "Also serve /metrics on this address, e.g. 127.0.0.1:9090")
+	serveCmd.Flags().BoolVar(&flagVerbose, "verbose", false, "Log each request to stderr")
+	serveCmd.RegisterFlagCompletionFunc("openai-references", cobra.FixedCompletions(
+		[]cobra.Completion{openAIReferencesAppend, openAIReferencesField}, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.AddCommand(serveCmd)
+}
+
+func newGateway(apiKey string, timeout int, tokens []string, cacheTTL time.Duration, rateLimit int) *gateway {
+	gw := &gateway{
+		apiKey:  apiKey,
+		timeout: timeout,
+		cache:   newResponseCache(cacheTTL),
+		limiter: newRateLimiter(rateLimit),
+
+		openAIReferences: openAIReferencesAppend,
+	}
+	// Compare hashes so token checks take the same time for every length
+	for _, token := range tokens {
+		gw.tokens = append(gw.tokens, sha256.Sum256([]byte(token)))
+	}
+	return gw
+}
+
+// listen opens a TCP listener, or a unix socket for "unix:" addresses.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixListenPrefix)
+	if !ok {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return listener, nil
+	}
+
+	// Remove a socket left behind by a previous run, but never a regular file
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	// The socket is the access control when no tokens are configured, so
+	// create it owner-only rather than tightening it after other users
+	// could have connected
+	var listener net.Listener
+	err := withUmask(0o177, func() (err error) {
+		listener, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	// Platforms without a umask rely on this
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// loadTokens combines --token values with the lines of --token-file.
+func loadTokens(tokens []string, file string) ([]string, error) {
+	var result []string
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			result = append(result, token)
+		}
+	}
+
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file: %w", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				result = append(result, line)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (gw *gateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+gatewayHealthPath, gw.handleHealth)
+	mux.HandleFunc("POST "+gatewayFastGPTPath, gw.handleFastGPT)
+	mux.HandleFunc("POST "+openAIChatPath, gw.handleOpenAIChat)
+	mux.HandleFunc("GET "+openAIModelsPath, gw.handleOpenAIModels)
+	mux.HandleFunc("GET "+metricsPath, gw.handleMetrics)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(recorder, r)
+		// Label by route, not raw path, so unknown paths cannot grow the series
+		path := r.URL.Path
+		if _, pattern := mux.Handler(r); pattern == "" {
+			path = "other"
+		}
+		metrics.observeHTTPRequest(path, recorder.status)
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming responses through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (gw *gateway) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeGatewayJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleMetrics serves metrics to token holders only, since request counts
+// and latencies reveal how the gateway is used. --metrics-addr serves them
+// without a token on an address of the operator's choosing.
+func (gw *gateway) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !gw.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kagi"`)
+		writeGatewayError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	handleMetrics(w, r)
+}
+
+func (gw *gateway) handleFastGPT(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// Log every response, including requests rejected before the cache
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	cache := "-"
+	defer func() { gw.logRequest(r, recorder.status, cache, start) }()
+	w = recorder
+
+	if !gw.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kagi"`)
+		writeGatewayError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	var req FastGPTRequest
+	// Default to caching and web search, as the API does when the fields
+	// are omitted
+	req.Cache = true
+	req.WebSearch = webSearchEnabled
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxGatewayBody)).Decode(&req); err != nil {
+		writeGatewayError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		writeGatewayError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	// Every request is forwarded with web search, so refuse to pretend
+	// otherwise
+	if !req.WebSearch {
+		writeGatewayError(w, http.StatusBadRequest, "web_search false is not supported by the gateway")
+		return
+	}
+
+	var body []byte
+	var err error
+	body, cache, err = gw.fetch(r.Context(), query, req.Cache)
+	if err != nil {
+		var gwErr *gatewayError
+		errors.As(err, &gwErr)
+		if gwErr.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(gwErr.retryAfter))
+		}
+		writeGatewayError(w, gwErr.status, gwErr.message)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Kagi-Cache", cache)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// gatewayError is a failure to answer a query, with the HTTP status to report.
+type gatewayError struct {
+	status     int
+	message    string
+	retryAfter int // seconds
+}
+
+func (e *gatewayError) Error() string { return e.message }
+
+// fetch returns the FastGPT response body for query from the cache, or from
+// the API when useCache is false or the query is not cached. The second
+// result is the cache status: "hit", "miss" or "limited". Errors are always
+// *gatewayError.
+func (gw *gateway) fetch(ctx context.Context, query string, useCache bool) ([]byte, string, error) {
+	if body, ok := gw.cache.get(query); ok && useCache {
+		metrics.observeCache("hit")
+		return body, "hit", nil
+	}
+	metrics.observeCache("miss")
+
+	if wait := gw.limiter.reserve(); wait > 0 {
+		return nil, "limited", &gatewayError{
+			status:     http.StatusTooManyRequests,
+			message:    "gateway rate limit exceeded, try again later",
+			retryAfter: int(wait.Seconds() + 1),
+		}
+	}
+
+	resp, err := gw.query(ctx, gw.apiKey, query, gw.timeout)
+	if err != nil {
+		return nil, "miss", upstreamError(err)
+	}
+	body := resp.Raw
+	if len(body) == 0 {
+		body, err = json.Marshal(resp)
+		if err != nil {
+			return nil, "miss", &gatewayError{status: http.StatusInternalServerError, message: "failed to marshal response"}
+		}
+	}
+	gw.cache.put(query, body)
+
+	return body, "miss", nil
+}
+
+// upstreamError maps a failed API request to the gateway's response. A
+// rate limit is passed on so that clients back off, and a rejected server
+// key is reported as the gateway's fault rather than a transient failure
+// worth retrying. Anything else is a bad gateway.
+func upstreamError(err error) *gatewayError {
+	var apiErr *apiStatusError
+	if errors.As(err, &apiErr) {
+		switch apiErr.status {
+		case http.StatusTooManyRequests:
+			return &gatewayError{
+				status:     http.StatusTooManyRequests,
+				message:    err.Error(),
+				retryAfter: retryAfterSeconds(apiErr.retryAfter),
+			}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return &gatewayError{
+				status:  http.StatusServiceUnavailable,
+				message: "gateway misconfigured: the API rejected the server's API key",
+			}
+		}
+	}
+	return &gatewayError{status: http.StatusBadGateway, message: err.Error()}
+}
+
+// retryAfterSeconds parses a Retry-After header, in seconds or as an HTTP
+// date, returning 0 when it is missing or invalid.
+func retryAfterSeconds(header string) int {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds > 0 {
+		return seconds
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(0, int(time.Until(date).Seconds()+1))
+	}
+	return 0
+}
+
+// authorized reports whether the request carries one of the configured
+// tokens. Without tokens every client is allowed. "Bot" is accepted as well
+// as "Bearer" so existing FastGPT clients work unchanged.
+func (gw *gateway) authorized(r *http.Request) bool {
+	if len(gw.tokens) == 0 {
+		return true
+	}
+
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, bearerAuthPrefix)
+	if !ok {
+		token, ok = strings.CutPrefix(header, authHeaderPrefix)
+	}
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	match := 0
+	for _, allowed := range gw.tokens {
+		match |= subtle.ConstantTimeCompare(sum[:], allowed[:])
+	}
+	return match == 1
+}
+
+func (gw *gateway) logRequest(r *http.Request, status int, cache string, start time.Time) {
+	if gw.verbose {
+		fmt.Fprintf(os.Stderr, "%s %s %s %d cache=%s %dms\n", time.Now().Format(time.RFC3339), r.Method, r.URL.Path, status, cache, time.Since(start).Milliseconds())
+	}
+}
+
+// writeGatewayError responds with an error shaped like the FastGPT API's.
+func writeGatewayError(w http.ResponseWriter, status int, message string) {
+	var apiError FastGPTError
+	apiError.Error = append(apiError.Error, struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{Code: status, Msg: message})
+	writeGatewayJSON(w, status, apiError)
+}
+
+func writeGatewayJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// responseCache is a shared in-memory cache of response bodies by query.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry), now: time.Now}
+}
+
+func (c *responseCache) get(query string) ([]byte, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[query]
+	if !ok || c.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *responseCache) put(query string, body []byte) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxCacheEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	// Still full of live entries: drop an arbitrary one
+	if len(c.entries) >= maxCacheEntries {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[query] = cacheEntry{body: body, expires: now.Add(c.ttl)}
+}
+
+// rateLimiter is a token bucket allowing perMinute requests per minute,
+// with bursts of up to perMinute requests.
+type rateLimiter struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	perSec   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		now:      time.Now,
+	}
+}
+
+// reserve takes a token, returning zero, or how long until one is available.
+func (l *rateLimiter) reserve() time.Duration {
+	if l.capacity == 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.perSec)
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.perSec * float64(time.Second))
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestGateway(tokens []string, cacheTTL time.Duration, rateLimit int) (*gateway, *int) {
+	calls := 0
+	gw := newGateway("server-key", defaultTimeout, tokens, cacheTTL, rateLimit)
+	gw.query = func(ctx context.Context, apiKey, query string, timeout int) (*FastGPTResponse, error) {
+		calls++
+		if apiKey != "server-key" {
+			return nil, errors.New("wrong key")
+		}
+		if query == "fail" {
+			return nil, errors.New("API request failed [1]: upstream error")
+		}
+		resp := createTestResponse()
+		resp.Raw = []byte(`{"meta":{"id":"x"},"data":{"output":"answer to ` + query + `"}}`)
+		return resp, nil
+	}
+	return gw, &calls
+}
+
+func gatewayRequest(gw *gateway, method, path, body, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	gw.handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway(t *testing.T) {
+	t.Run("healthz", func(t *testing.T) {
+		gw, _ := newTestGateway([]string{"secret"}, 0, 0)
+		rec := gatewayRequest(gw, http.MethodGet, gatewayHealthPath, "", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ok"`) {
+			t.Errorf("healthz = %d %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("forwards query with server key", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), "answer to golang") {
+			t.Errorf("Body should be the upstream response: %s", rec.Body)
+		}
+	})
+
+	t.Run("accepts web_search true", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang","web_search":true}`, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d; body %s", rec.Code, rec.Body)
+		}
+	})
+
+	authTests := []struct {
+		name string
+		auth string
+		code int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"bearer token", "Bearer secret", http.StatusOK},
+		{"second token", "Bearer other", http.StatusOK},
+		{"bot prefix", "Bot secret", http.StatusOK},
+	}
+
+	for _, tt := range authTests {
+		t.Run(tt.name, func(t *testing.T) {
+			gw, _ := newTestGateway([]string{"secret", "other"}, 0, 0)
+			rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, tt.auth)
+			if rec.Code != tt.code {
+				t.Errorf("status = %d; want %d", rec.Code, tt.code)
+			}
+		})
+	}
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		gw, calls := newTestGateway(nil, 0, 0)
+		for _, body := range []string{`not json`, `{}`, `{"query":"  "}`, `{"query":"golang","web_search":false}`} {
+			rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, body, "")
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("body %q: status = %d; want 400", body, rec.Code)
+			}
+		}
+		if *calls != 0 {
+			t.Errorf("Invalid requests should not reach the API")
+		}
+	})
+
+	t.Run("upstream errors are bad gateway", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, 0, 0)
+		rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"fail"}`, "")
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("status = %d; want 502", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `"msg":"API request failed [1]: upstream error"`) {
+			t.Errorf("Error should be FastGPT-shaped: %s", rec.Body)
+		}
+	})
+
+	upstreamTests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantStatus int
+		wantRetry  string
+		wantMsg    string
+	}{
+		{"upstream rate limit passed on", http.StatusTooManyRequests, "7", http.StatusTooManyRequests, "7", "rate limit"},
+		{"upstream rate limit without Retry-After", http.StatusTooManyRequests, "", http.StatusTooManyRequests, "", "rate limit"},
+		{"rejected server key is a misconfiguration", http.StatusUnauthorized, "", http.StatusServiceUnavailable, "", "gateway misconfigured"},
+		{"upstream server error is bad gateway", http.StatusInternalServerError, "", http.StatusBadGateway, "", "HTTP 500"},
+	}
+	for _, tt := range upstreamTests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				if tt.status != http.StatusInternalServerError {
+					w.Write([]byte(`{"error":[{"code":1,"msg":"upstream"}]}`))
+				}
+			}))
+			defer upstream.Close()
+			previous := apiURL
+			apiURL = upstream.URL
+			defer func() { apiURL = previous }()
+
+			gw := newGateway("server-key", defaultTimeout, nil, 0, 0)
+			gw.query = queryKagi
+			rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q; want %q", got, tt.wantRetry)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMsg) {
+				t.Errorf("body = %s; want it to mention %q", rec.Body, tt.wantMsg)
+			}
+		})
+	}
+
+	t.Run("caches responses", func(t *testing.T) {
+		gw, calls := newTestGateway(nil, time.Minute, 0)
+		first := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+		second := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+		if *calls != 1 {
+			t.Errorf("API calls = %d; want 1", *calls)
+		}
+		if first.Header().Get("X-Kagi-Cache") != "miss" || second.Header().Get("X-Kagi-Cache") != "hit" {
+			t.Errorf("X-Kagi-Cache = %q, %q; want miss, hit", first.Header().Get("X-Kagi-Cache"), second.Header().Get("X-Kagi-Cache"))
+		}
+		if first.Body.String() != second.Body.String() {
+			t.Errorf("Cached body differs")
+		}
+
+		gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang","cache":false}`, "")
+		if *calls != 2 {
+			t.Errorf("cache:false should bypass the cache")
+		}
+	})
+
+	t.Run("rate limits upstream calls", func(t *testing.T) {
+		gw, _ := newTestGateway(nil, time.Minute, 2)
+		for i, query := range []string{"a", "b", "a"} {
+			if rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"`+query+`"}`, ""); rec.Code != http.StatusOK {
+				t.Errorf("request %d: status = %d; want 200 (cache hits are not limited)", i, rec.Code)
+			}
+		}
+		rec := gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"c"}`, "")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d; want 429", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("Missing Retry-After header")
+		}
+	})
+}
+
+func TestGatewayLogsEveryResponse(t *testing.T) {
+	gw, _ := newTestGateway([]string{"secret"}, time.Minute, 0)
+	gw.verbose = true
+
+	logged := captureStderr(t, func() {
+		gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "")
+		gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":""}`, "Bearer secret")
+		gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "Bearer secret")
+		gatewayRequest(gw, http.MethodPost, gatewayFastGPTPath, `{"query":"golang"}`, "Bearer secret")
+	})
+	for _, want := range []string{
+		gatewayFastGPTPath + " 401 cache=- ",
+		gatewayFastGPTPath + " 400 cache=- ",
+		gatewayFastGPTPath + " 200 cache=miss ",
+		gatewayFastGPTPath + " 200 cache=hit ",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("Log missing %q:\n%s", want, logged)
+		}
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := newResponseCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.put("q", []byte("body"))
+	if _, ok := cache.get("q"); !ok {
+		t.Fatalf("Expected cache hit")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.get("q"); ok {
+		t.Errorf("Expected expired entry to miss")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(60)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 60; i++ {
+		if wait := limiter.reserve(); wait != 0 {
+			t.Fatalf("request %d: wait = %v; want burst of 60", i, wait)
+		}
+	}
+	if wait := limiter.reserve(); wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v; want up to 1s", wait)
+	}
+
+	now = now.Add(2 * time.Second)
+	if wait := limiter.reserve(); wait != 0 {
+		t.Errorf("Expected a token after refill, wait = %v", wait)
+	}
+
+	if wait := newRateLimiter(0).reserve(); wait != 0 {
+		t.Errorf("Zero rate limit should be unlimited")
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(file, []byte("# clients\nalpha\n\n  beta  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := loadTokens([]string{"flag", " "}, file)
+	if err != nil {
+		t.Fatalf("loadTokens failed: %v", err)
+	}
+	if strings.Join(tokens, ",") != "flag,alpha,beta" {
+		t.Errorf("tokens = %v; want [flag alpha beta]", tokens)
+	}
+
+	if _, err := loadTokens(nil, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected error for missing token file")
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kagi.sock")
+	listener, err := listen(unixListenPrefix + path)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Socket mode = %v; want 0600", info.Mode().Perm())
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	if got := retryAfterSeconds("30"); got != 30 {
+		t.Errorf("retryAfterSeconds(30) = %d", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := retryAfterSeconds(date); got < 55 || got > 61 {
+		t.Errorf("retryAfterSeconds(%q) = %d; want about 60", date, got)
+	}
+	for _, header := range []string{"", "soon", "-5"} {
+		if got := retryAfterSeconds(header); got != 0 {
+			t.Errorf("retryAfterSeconds(%q) = %d; want 0", header, got)
+		}
+	}
+}
+
+func TestWithUmask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no umask on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	err := withUmask(0o177, func() error {
+		return os.WriteFile(path, nil, 0o666)
+	})
+	if err != nil {
+		t.Fatalf("withUmask failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("File mode = %v; want 0600", info.Mode().Perm())
+	}
+}
+
+func TestServeShutdownFinishesRequests(t *testing.T) {
+	var returned atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		if returned.Load() {
+			t.Error("serve returned before the in-flight request finished")
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Write([]byte(`{"meta":{"id":"x","node":"n","ms":1},"data":{"output":"slow answer","tokens":1,"references":[]}}`))
+	}))
+	defer upstream.Close()
+	t.Setenv(envAPIKey, "server-key")
+	t.Setenv(envEndpoint, upstream.URL)
+
+	socket := filepath.Join(t.TempDir(), "kagi.sock")
+	previous := flagListen
+	flagListen = unixListenPrefix + socket
+	defer func() { flagListen = previous }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveCmd.SetContext(ctx)
+	done := make(chan error, 1)
+	go func() {
+		err := serveCmd.RunE(serveCmd, nil)
+		returned.Store(true)
+		done <- err
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Cancel while the request waits on the upstream
+	time.AfterFunc(100*time.Millisecond, cancel)
+	resp, err := client.Post("http://kagi"+gatewayFastGPTPath, contentTypeJSON, strings.NewReader(`{"query":"slow"}`))
+	if err != nil {
+		t.Fatalf("In-flight request failed during shutdown: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "slow answer") {
+		t.Errorf("Response = %d %s; want the answer", resp.StatusCode, body)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve returned %v after a clean shutdown; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after shutdown")
+	}
+}
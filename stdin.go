@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"golang.org/x/term"
+)
+
+const (
+	// --stdin modes
+	stdinAuto    = "auto"
+	stdinContext = "context"
+	stdinQuery   = "query"
+	stdinIgnore  = "ignore"
+
+	// defaultStdinTemplate puts piped input before the question from the
+	// arguments, delimited so the model can tell them apart
+	defaultStdinTemplate = "<context>\n{{.Context}}\n</context>\n\n{{.Question}}"
+
+	// largeStdinBytes is the stdin size that triggers a warning
+	largeStdinBytes = 32 << 10
+)
+
+// errNoQuery is returned when neither the arguments nor stdin hold a query.
+var errNoQuery = errors.New("no query provided\nUsage: kagi [flags] <query...>")
+
+// stdinPrompt is the value --stdin-template is executed against.
+type stdinPrompt struct {
+	Context  string
+	Question string
+}
+
+// shouldReadStdin reports whether readQuery reads stdin. In auto mode, stdin
+// is read as context only when it is a pipe or file, so a question in the
+// arguments never waits on an inherited, idle stdin.
+func shouldReadStdin(mode string, hasQuestion bool) bool {
+	switch mode {
+	case stdinContext, stdinQuery:
+		return true
+	case stdinIgnore:
+		return false
+	}
+	if hasQuestion {
+		return stdinPiped()
+	}
+	return !term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
+// composeQuery builds the query from the question in the arguments and the
+// input read from stdin, according to the --stdin mode. It also returns the
+// question on its own, which is shown in headings and used to name files;
+// piped context is sent to the API but not displayed.
+func composeQuery(question, input, mode, tmplText string) (query, asked string, err error) {
+	question, input, err = splitStdin(question, input, mode)
+	if err != nil {
+		return "", "", err
+	}
+	if question == "" {
+		return "", "", errNoQuery
+	}
+	if input == "" {
+		return question, question, nil
+	}
+
+	tmpl, err := parseStdinTemplate(tmplText)
+	if err != nil {
+		return "", "", err
+	}
+	var composed strings.Builder
+	if err := tmpl.Execute(&composed, stdinPrompt{Context: input, Question: question}); err != nil {
+		return "", "", fmt.Errorf("invalid --stdin-template: %w", err)
+	}
+	return strings.TrimSpace(composed.String()), question, nil
+}
+
+// splitStdin applies the --stdin mode to the question in the arguments and
+// the input read from stdin, returning the question and the context to
+// combine with it.
+func splitStdin(question, input, mode string) (string, string, error) {
+	switch mode {
+	case stdinQuery:
+		if question != "" {
+			return "", "", fmt.Errorf("--stdin=query reads the whole query from stdin and takes no arguments")
+		}
+		return input, "", nil
+	case stdinContext:
+		if question == "" {
+			return "", "", fmt.Errorf("--stdin=context needs a question in the arguments\nUsage: <command> | kagi --stdin=context <question...>")
+		}
+		if input == "" {
+			return "", "", fmt.Errorf("--stdin=context needs input on stdin")
+		}
+	}
+
+	// Stdin alone is the query
+	if question == "" {
+		return input, "", nil
+	}
+	return question, input, nil
+}
+
+// parseStdinTemplate parses --stdin-template, executing it once with empty
+// values so that unknown fields are reported before the query is composed.
+func parseStdinTemplate(tmplText string) (*template.Template, error) {
+	tmpl, err := template.New("stdin").Parse(tmplText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --stdin-template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, stdinPrompt{}); err != nil {
+		return nil, fmt.Errorf("invalid --stdin-template: %w", err)
+	}
+	return tmpl, nil
+}
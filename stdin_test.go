@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestComposeQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		question string
+		input    string
+		mode     string
+		tmpl     string
+		want     string
+		// wantQuestion is the question displayed, without stdin context
+		wantQuestion string
+		wantErr      string
+	}{
+		{"question only", "review this", "", stdinAuto, defaultStdinTemplate, "review this", "review this", ""},
+		{"stdin only", "", "explain kubernetes", stdinAuto, defaultStdinTemplate, "explain kubernetes", "explain kubernetes", ""},
+		{"stdin as context", "review this", "+added line", stdinAuto, defaultStdinTemplate, "<context>\n+added line\n</context>\n\nreview this", "review this", ""},
+		{"custom template", "summarise", "text", stdinAuto, "{{.Question}}:\n\n---\n{{.Context}}\n---", "summarise:\n\n---\ntext\n---", "summarise", ""},
+		{"context mode", "review", "diff", stdinContext, defaultStdinTemplate, "<context>\ndiff\n</context>\n\nreview", "review", ""},
+		{"context mode without question", "", "diff", stdinContext, defaultStdinTemplate, "", "", "needs a question"},
+		{"context mode without input", "review", "", stdinContext, defaultStdinTemplate, "", "", "needs input on stdin"},
+		{"query mode", "", "typed query", stdinQuery, defaultStdinTemplate, "typed query", "typed query", ""},
+		{"query mode with arguments", "review", "typed query", stdinQuery, defaultStdinTemplate, "", "", "takes no arguments"},
+		{"nothing", "", "", stdinAuto, defaultStdinTemplate, "", "", "no query provided"},
+		{"invalid template", "review", "diff", stdinAuto, "{{.Context", "", "", "invalid --stdin-template"},
+		{"unknown template field", "review", "diff", stdinAuto, "{{.Diff}}", "", "", "invalid --stdin-template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, question, err := composeQuery(tt.question, tt.input, tt.mode, tt.tmpl)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("composeQuery() error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("composeQuery() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("composeQuery() = %q; want %q", got, tt.want)
+			}
+			if question != tt.wantQuestion {
+				t.Errorf("composeQuery() question = %q; want %q", question, tt.wantQuestion)
+			}
+		})
+	}
+}
+
+func TestShouldReadStdin(t *testing.T) {
+	if !shouldReadStdin(stdinContext, true) || !shouldReadStdin(stdinQuery, false) {
+		t.Errorf("context and query modes always read stdin")
+	}
+	if shouldReadStdin(stdinIgnore, false) {
+		t.Errorf("ignore mode never reads stdin")
+	}
+}
+
+func TestReadQueryInvalidStdinMode(t *testing.T) {
+	previous := flagStdin
+	flagStdin = "sometimes"
+	defer func() { flagStdin = previous }()
+
+	if _, _, _, err := readQuery([]string{"test"}); err == nil || !strings.Contains(err.Error(), "--stdin") {
+		t.Errorf("Expected invalid --stdin error, got %v", err)
+	}
+}
+
+func TestReadQueryStdinContext(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("+added line\n")
+	w.Close()
+	previous := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = previous; r.Close() }()
+
+	question, input, mode, err := readQuery([]string{"review", "this"})
+	if err != nil {
+		t.Fatalf("readQuery failed: %v", err)
+	}
+	if question != "review this" || input != "+added line" {
+		t.Errorf("readQuery() = %q, %q; want the arguments and the piped input", question, input)
+	}
+
+	query, question, err := composeQuery(question, input, mode, defaultStdinTemplate)
+	if err != nil {
+		t.Fatalf("composeQuery failed: %v", err)
+	}
+	if !strings.Contains(query, "+added line") {
+		t.Errorf("query = %q; want the piped context", query)
+	}
+	if question != "review this" {
+		t.Errorf("question = %q; want the arguments only", question)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	templatesDirName = "templates"
+	templateExt      = ".tmpl"
+)
+
+// templateData is the value templates are executed against.
+type templateData struct {
+	Query      string
+	Output     string
+	References []Reference
+	Response   *FastGPTResponse
+	Config     Config
+}
+
+// templateFuncs are the helper functions available to output templates.
+var templateFuncs = template.FuncMap{
+	"stripCitations": stripCitations,
+	"wrap":           wrapText,
+	"plain":          markdownToPlain,
+	"json":           toJSON,
+	"join":           joinAny,
+	"truncate":       truncateText,
+}
+
+// loadTemplate parses the template given by --template or --template-file.
+// A --template value without "{{" is the name of a template in the config
+// directory. Every template in that directory is also loaded, so templates
+// can include each other with {{template "name" .}}.
+func loadTemplate(inline, file string) (*template.Template, error) {
+	if inline != "" && file != "" {
+		return nil, fmt.Errorf("--template and --template-file cannot be used together")
+	}
+
+	// The root name cannot clash with a template file name
+	tmpl := template.New("--template").Funcs(templateFuncs)
+
+	dir := filepath.Join(configDir(), templatesDirName)
+	named, err := filepath.Glob(filepath.Join(dir, "*"+templateExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	for _, path := range named {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), templateExt)
+		if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("invalid template %q: %w", path, err)
+		}
+	}
+
+	var source string
+	switch {
+	case file != "":
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file: %w", err)
+		}
+		source = string(content)
+	case strings.Contains(inline, "{{"):
+		source = inline
+	default:
+		named := tmpl.Lookup(inline)
+		if named == nil {
+			return nil, fmt.Errorf("template %q not found\nNamed templates are read from %s", inline, filepath.Join(dir, inline+templateExt))
+		}
+		return named, nil
+	}
+
+	if _, err := tmpl.Parse(source); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// formatTemplate_output renders the response with the user's template.
+func formatTemplate_output(resp *FastGPTResponse, config *Config) (string, error) {
+	// Templates are user-controlled, but there is no reason to expose the key
+	safeConfig := *config
+	safeConfig.APIKey = ""
+	safeConfig.Template = nil
+
+	data := templateData{
+		Query:      config.Question,
+		Output:     resp.Data.Output,
+		References: resp.Data.References,
+		Response:   resp,
+		Config:     safeConfig,
+	}
+
+	var output strings.Builder
+	if err := config.Template.Execute(&output, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	// Match the other formats, which always end with a newline
+	result := output.String()
+	if result != "" && !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+
+	return result, nil
+}
+
+// configDir returns the kagi configuration directory, which can be
+// overridden with KAGI_CONFIG_DIR.
+func configDir() string {
+	if dir := os.Getenv(envConfigDir); dir != "" {
+		return dir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		// No home directory; fall back to a path that will simply not exist
+		return filepath.Join(".", ".kagi")
+	}
+	return filepath.Join(dir, "kagi")
+}
+
+// Template helper functions. Arguments are ordered so the text comes last,
+// which lets them be used in pipelines: {{.Output | wrap 80}}.
+
+// citationWithSpacePattern matches a citation marker and the space before it
+var citationWithSpacePattern = regexp.MustCompile(`[ \t]*【\d+】`)
+
+// stripCitations removes citation markers such as 【1】.
+func stripCitations(text string) string {
+	return citationWithSpacePattern.ReplaceAllString(text, "")
+}
+
+// wrapText word-wraps each line of text to width characters.
+func wrapText(width int, text string) string {
+	if width <= 0 {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var wrapped strings.Builder
+		lineLen := 0
+		for _, word := range strings.Fields(line) {
+			wordLen := len([]rune(word))
+			if lineLen > 0 && lineLen+1+wordLen > width {
+				wrapped.WriteString("\n")
+				lineLen = 0
+			} else if lineLen > 0 {
+				wrapped.WriteString(" ")
+				lineLen++
+			}
+			wrapped.WriteString(word)
+			lineLen += wordLen
+		}
+		lines[i] = wrapped.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// markdownToPlain converts the answer markdown to plain text.
+func markdownToPlain(src string) string {
+	var out []string
+
+	for _, block := range parseMarkdown(src) {
+		switch block.Kind {
+		case mdCodeBlock:
+			out = append(out, block.Text)
+		case mdList:
+			var items []string
+			for i, item := range block.Items {
+				marker := "-"
+				if block.Ordered {
+					marker = fmt.Sprintf("%d.", i+1)
+				}
+				items = append(items, marker+" "+inlineToPlain(item))
+			}
+			out = append(out, strings.Join(items, "\n"))
+		case mdRule:
+			continue
+		default:
+			out = append(out, inlineToPlain(block.Text))
+		}
+	}
+
+	return strings.Join(out, "\n\n")
+}
+
+func inlineToPlain(src string) string {
+	var out strings.Builder
+	for _, span := range parseInline(src) {
+		out.WriteString(span.Text)
+		if span.Kind == mdLink && span.URL != "" && span.URL != span.Text {
+			out.WriteString(" (" + span.URL + ")")
+		}
+	}
+	return out.String()
+}
+
+// truncateText shortens text to at most n characters.
+func truncateText(n int, text string) string {
+	if n <= 0 {
+		return ""
+	}
+	return truncateRunes(text, n)
+}
+
+// toJSON encodes v as compact JSON.
+func toJSON(v any) (string, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// joinAny joins the elements of any slice with sep.
+func joinAny(sep string, list any) (string, error) {
+	if strs, ok := list.([]string); ok {
+		return strings.Join(strs, sep), nil
+	}
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expected a list, got %T", list)
+	}
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
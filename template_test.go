@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTemplate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(envConfigDir, dir)
+
+	templatesDir := filepath.Join(dir, templatesDirName)
+	if err := os.MkdirAll(templatesDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templatesDir, "urls.tmpl"), []byte(`{{range .References}}{{.URL}}{{"\n"}}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := createTestResponse()
+
+	render := func(t *testing.T, inline, file string) string {
+		t.Helper()
+		tmpl, err := loadTemplate(inline, file)
+		if err != nil {
+			t.Fatalf("loadTemplate failed: %v", err)
+		}
+		result, err := formatTemplate_output(resp, &Config{Question: "test query", APIKey: "secret", Template: tmpl})
+		if err != nil {
+			t.Fatalf("formatTemplate_output failed: %v", err)
+		}
+		return result
+	}
+
+	t.Run("inline template", func(t *testing.T) {
+		result := render(t, "{{.Query}}: {{.Response.Data.Tokens}} tokens", "")
+		if result != "test query: 50 tokens\n" {
+			t.Errorf("Rendered template = %q", result)
+		}
+	})
+
+	t.Run("named template", func(t *testing.T) {
+		result := render(t, "urls", "")
+		if result != "https://example.com/1\nhttps://example.com/2\n" {
+			t.Errorf("Rendered template = %q", result)
+		}
+	})
+
+	t.Run("inline template includes named template", func(t *testing.T) {
+		result := render(t, `{{template "urls" .}}`, "")
+		if !strings.Contains(result, "https://example.com/2") {
+			t.Errorf("Rendered template = %q", result)
+		}
+	})
+
+	t.Run("template file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "summary.tmpl")
+		if err := os.WriteFile(file, []byte("{{len .References}} references\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		result := render(t, "", file)
+		if result != "2 references\n" {
+			t.Errorf("Rendered template = %q", result)
+		}
+	})
+
+	t.Run("API key not exposed", func(t *testing.T) {
+		result := render(t, "[{{.Config.APIKey}}]", "")
+		if result != "[]\n" {
+			t.Errorf("Template could read the API key: %q", result)
+		}
+	})
+
+	t.Run("unknown named template", func(t *testing.T) {
+		_, err := loadTemplate("missing", "")
+		if err == nil || !strings.Contains(err.Error(), `template "missing" not found`) {
+			t.Errorf("Expected not found error, got: %v", err)
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		if _, err := loadTemplate("{{.Query", ""); err == nil {
+			t.Errorf("Expected parse error")
+		}
+	})
+
+	t.Run("both flags rejected", func(t *testing.T) {
+		if _, err := loadTemplate("{{.Query}}", "file.tmpl"); err == nil {
+			t.Errorf("Expected error when both --template and --template-file are set")
+		}
+	})
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Run("stripCitations", func(t *testing.T) {
+		result := stripCitations("Go is fast 【1】. It is simple【2】.")
+		if result != "Go is fast. It is simple." {
+			t.Errorf("stripCitations() = %q", result)
+		}
+	})
+
+	t.Run("wrap", func(t *testing.T) {
+		result := wrapText(10, "the quick brown fox jumps\n\nover")
+		if result != "the quick\nbrown fox\njumps\n\nover" {
+			t.Errorf("wrapText() = %q", result)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		result := markdownToPlain("## Title\n\n**Bold** and [link](https://go.dev)\n\n- one\n- `two`")
+		expected := "Title\n\nBold and link (https://go.dev)\n\n- one\n- two"
+		if result != expected {
+			t.Errorf("markdownToPlain() = %q; want %q", result, expected)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		result, err := toJSON(map[string]int{"a": 1})
+		if err != nil || result != `{"a":1}` {
+			t.Errorf("toJSON() = %q, %v", result, err)
+		}
+	})
+
+	t.Run("join", func(t *testing.T) {
+		result, err := joinAny(", ", []string{"a", "b"})
+		if err != nil || result != "a, b" {
+			t.Errorf("joinAny() = %q, %v", result, err)
+		}
+		result, err = joinAny("-", []int{1, 2})
+		if err != nil || result != "1-2" {
+			t.Errorf("joinAny() = %q, %v", result, err)
+		}
+		if _, err := joinAny(",", "not a list"); err == nil {
+			t.Errorf("joinAny() should reject non-list")
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		if result := truncateText(5, "hello world"); result != "hell…" {
+			t.Errorf("truncateText() = %q", result)
+		}
+		if result := truncateText(0, "hello"); result != "" {
+			t.Errorf("truncateText(0) = %q; want empty", result)
+		}
+	})
+}
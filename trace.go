@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTrace records the phases of an API request for --debug and
+// --trace-file. It travels in the request context, like httptrace itself;
+// every method is a no-op on a nil receiver.
+type requestTrace struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	end          time.Time
+
+	connReused      bool
+	remoteAddr      string
+	tlsVersion      string
+	method          string
+	url             string
+	requestHeaders  http.Header
+	requestBytes    int
+	status          string
+	responseHeaders http.Header
+	responseBytes   int
+	kagiMS          int
+	err             error
+}
+
+// traceReport is the JSON form of a trace. Durations are in milliseconds;
+// phases that did not happen, e.g. DNS on a reused connection, are omitted.
+type traceReport struct {
+	Method           string              `json:"method"`
+	URL              string              `json:"url"`
+	Status           string              `json:"status,omitempty"`
+	RemoteAddr       string              `json:"remote_addr,omitempty"`
+	TLSVersion       string              `json:"tls_version,omitempty"`
+	ConnectionReused bool                `json:"connection_reused"`
+	RequestHeaders   map[string][]string `json:"request_headers"`
+	ResponseHeaders  map[string][]string `json:"response_headers,omitempty"`
+	RequestBytes     int                 `json:"request_bytes"`
+	ResponseBytes    int                 `json:"response_bytes"`
+	DNSMS            *float64            `json:"dns_ms,omitempty"`
+	ConnectMS        *float64            `json:"connect_ms,omitempty"`
+	TLSMS            *float64            `json:"tls_handshake_ms,omitempty"`
+	TTFBMS           *float64            `json:"time_to_first_byte_ms,omitempty"`
+	TotalMS          *float64            `json:"total_ms,omitempty"`
+	KagiMS           *int                `json:"kagi_ms,omitempty"`
+	OverheadMS       *float64            `json:"overhead_ms,omitempty"`
+	Error            string              `json:"error,omitempty"`
+}
+
+type requestTraceKey struct{}
+
+// withRequestTrace returns a context that makes queryKagi record into trace.
+func withRequestTrace(ctx context.Context, trace *requestTrace) context.Context {
+	return context.WithValue(ctx, requestTraceKey{}, trace)
+}
+
+func requestTraceFrom(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(requestTraceKey{}).(*requestTrace)
+	return trace
+}
+
+// attach starts the trace and hooks it into req.
+func (t *requestTrace) attach(req *http.Request, bodyBytes int) *http.Request {
+	if t == nil {
+		return req
+	}
+	t.mu.Lock()
+	t.start = time.Now()
+	t.method = req.Method
+	t.url = req.URL.String()
+	t.requestHeaders = redactHeaders(req.Header)
+	t.requestBytes = bodyBytes
+	t.mu.Unlock()
+
+	// Hooks can run on other goroutines, so every field write is locked
+	set := func(field *time.Time) {
+		t.mu.Lock()
+		*field = time.Now()
+		t.mu.Unlock()
+	}
+	clientTrace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { set(&t.dnsDone) },
+		ConnectStart:      func(string, string) { set(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { set(&t.connectDone) },
+		TLSHandshakeStart: func() { set(&t.tlsStart) },
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			t.mu.Lock()
+			t.tlsDone = time.Now()
+			t.tlsVersion = tls.VersionName(state.Version)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.connReused = info.Reused
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { set(&t.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace))
+}
+
+// response records the response status, headers and body size.
+func (t *requestTrace) response(resp *http.Response, bodyBytes int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = resp.Status
+	t.responseHeaders = redactHeaders(resp.Header)
+	t.responseBytes = bodyBytes
+}
+
+// finish ends the trace with the result of the request.
+func (t *requestTrace) finish(result *FastGPTResponse, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.end = time.Now()
+	t.err = err
+	if result != nil {
+		t.kagiMS = result.Meta.MS
+	}
+}
+
+func (t *requestTrace) report() traceReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := traceReport{
+		Method:           t.method,
+		URL:              t.url,
+		Status:           t.status,
+		RemoteAddr:       t.remoteAddr,
+		TLSVersion:       t.tlsVersion,
+		ConnectionReused: t.connReused,
+		RequestHeaders:   t.requestHeaders,
+		ResponseHeaders:  t.responseHeaders,
+		RequestBytes:     t.requestBytes,
+		ResponseBytes:    t.responseBytes,
+		DNSMS:            phaseMS(t.dnsStart, t.dnsDone),
+		ConnectMS:        phaseMS(t.connectStart, t.connectDone),
+		TLSMS:            phaseMS(t.tlsStart, t.tlsDone),
+		TTFBMS:           phaseMS(t.start, t.firstByte),
+		TotalMS:          phaseMS(t.start, t.end),
+	}
+	if t.kagiMS > 0 && report.TotalMS != nil {
+		kagiMS := t.kagiMS
+		overhead := *report.TotalMS - float64(kagiMS)
+		report.KagiMS = &kagiMS
+		report.OverheadMS = &overhead
+	}
+	if t.err != nil {
+		report.Error = t.err.Error()
+	}
+	return report
+}
+
+// writeDebug prints the trace as Debug lines.
+func (t *requestTrace) writeDebug(w io.Writer) {
+	if t == nil {
+		return
+	}
+	r := t.report()
+	if r.Method == "" {
+		// The request failed before it was sent
+		return
+	}
+
+	fmt.Fprintf(w, "Debug: %s %s\n", r.Method, r.URL)
+	writeDebugHeaders(w, "Request header", r.RequestHeaders)
+	fmt.Fprintf(w, "Debug: Request body: %d bytes\n", r.RequestBytes)
+	if r.Status != "" {
+		fmt.Fprintf(w, "Debug: Response: %s (%d bytes)\n", r.Status, r.ResponseBytes)
+		writeDebugHeaders(w, "Response header", r.ResponseHeaders)
+	}
+	if r.ConnectionReused {
+		fmt.Fprintf(w, "Debug: Connection: reused %s\n", r.RemoteAddr)
+	} else if r.RemoteAddr != "" {
+		fmt.Fprintf(w, "Debug: Connection: new %s %s\n", r.RemoteAddr, r.TLSVersion)
+	}
+
+	phases := []struct {
+		name string
+		ms   *float64
+	}{
+		{"DNS lookup", r.DNSMS},
+		{"TCP connect", r.ConnectMS},
+		{"TLS handshake", r.TLSMS},
+		{"Time to first byte", r.TTFBMS},
+	}
+	for _, phase := range phases {
+		if phase.ms != nil {
+			fmt.Fprintf(w, "Debug: %s: %.1fms\n", phase.name, *phase.ms)
+		}
+	}
+	if r.TotalMS != nil {
+		fmt.Fprintf(w, "Debug: Total: %.1fms\n", *r.TotalMS)
+	}
+	if r.KagiMS != nil {
+		fmt.Fprintf(w, "Debug: Kagi reported: %dms (network and client overhead %.1fms)\n", *r.KagiMS, *r.OverheadMS)
+	}
+}
+
+func writeDebugHeaders(w io.Writer, label string, headers map[string][]string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "Debug: %s: %s: %s\n", label, name, strings.Join(headers[name], ", "))
+	}
+}
+
+// writeTraceFile writes the trace as indented JSON.
+func (t *requestTrace) writeTraceFile(path string) error {
+	if t == nil {
+		return nil
+	}
+	jsonBytes, err := json.MarshalIndent(t.report(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace: %w", err)
+	}
+	if err := os.WriteFile(path, append(jsonBytes, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write trace file: %w", err)
+	}
+	return nil
+}
+
+// sensitiveHeaders are replaced with *** in traces.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactHeaders copies headers, hiding credentials. The Authorization scheme
+// is kept so the trace still shows how the request authenticated.
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	if redacted == nil {
+		redacted = http.Header{}
+	}
+	for _, name := range sensitiveHeaders {
+		values := redacted[name]
+		for i, value := range values {
+			scheme, _, found := strings.Cut(value, " ")
+			if found && name == "Authorization" {
+				values[i] = scheme + " ***"
+			} else {
+				values[i] = "***"
+			}
+		}
+	}
+	return redacted
+}
+
+func phaseMS(start, end time.Time) *float64 {
+	if start.IsZero() || end.IsZero() {
+		return nil
+	}
+	ms := milliseconds(end.Sub(start))
+	return &ms
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tracedRequest sends a request to a test server with trace attached, the
+// way queryKagi does.
+func tracedRequest(t *testing.T, trace *requestTrace, url string) {
+	t.Helper()
+
+	ctx := withRequestTrace(context.Background(), trace)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`{"query":"test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", authHeaderPrefix+"secret-key")
+	req = requestTraceFrom(ctx).attach(req, 16)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	trace.response(resp, len(body))
+
+	result := createTestResponse()
+	result.Meta.MS = 1
+	trace.finish(result, nil)
+}
+
+func TestRequestTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret-cookie"})
+		w.Header().Set("Content-Type", contentTypeJSON)
+		io.WriteString(w, `{"data":{"output":"answer"}}`)
+	}))
+	defer server.Close()
+
+	trace := &requestTrace{}
+	tracedRequest(t, trace, server.URL)
+	report := trace.report()
+
+	if report.Method != http.MethodPost || report.Status != "200 OK" {
+		t.Errorf("method/status = %q/%q", report.Method, report.Status)
+	}
+	if report.RequestBytes != 16 || report.ResponseBytes != len(`{"data":{"output":"answer"}}`) {
+		t.Errorf("sizes = %d/%d", report.RequestBytes, report.ResponseBytes)
+	}
+	if report.ConnectMS == nil || report.TTFBMS == nil {
+		t.Errorf("Expected connect and time-to-first-byte timings: %+v", report)
+	}
+	if report.TLSMS != nil {
+		t.Errorf("Plain HTTP should have no TLS timing")
+	}
+	if report.KagiMS == nil || *report.KagiMS != 1 || report.OverheadMS == nil {
+		t.Errorf("Expected Kagi reported time and overhead")
+	}
+
+	var debug strings.Builder
+	trace.writeDebug(&debug)
+	output := debug.String()
+	for _, want := range []string{
+		"Debug: POST " + server.URL,
+		"Debug: Request header: Authorization: Bot ***",
+		"Debug: Response: 200 OK",
+		"Debug: Response header: Set-Cookie: ***",
+		"Debug: TCP connect: ",
+		"Debug: Time to first byte: ",
+		"Debug: Kagi reported: 1ms",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Debug output missing %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "secret-key") || strings.Contains(output, "secret-cookie") {
+		t.Errorf("Debug output leaks credentials:\n%s", output)
+	}
+}
+
+func TestRequestTraceTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	trace := &requestTrace{}
+	ctx := withRequestTrace(context.Background(), trace)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	req = trace.attach(req, 0)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	trace.finish(nil, nil)
+
+	report := trace.report()
+	if report.TLSMS == nil || report.TLSVersion == "" {
+		t.Errorf("Expected TLS handshake timing and version: %+v", report)
+	}
+}
+
+func TestWriteTraceFile(t *testing.T) {
+	trace := &requestTrace{}
+	req, _ := http.NewRequest(http.MethodPost, apiEndpoint, nil)
+	req.Header.Set("Authorization", authHeaderPrefix+"secret-key")
+	trace.attach(req, 10)
+	trace.finish(nil, errors.New("network request failed"))
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := trace.writeTraceFile(path); err != nil {
+		t.Fatalf("writeTraceFile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]any
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatalf("Trace is not valid JSON: %v", err)
+	}
+	if report["error"] != "network request failed" || report["url"] != apiEndpoint {
+		t.Errorf("Unexpected trace: %s", content)
+	}
+	if _, ok := report["total_ms"]; !ok {
+		t.Errorf("Trace missing total_ms for a sent request: %s", content)
+	}
+	if strings.Contains(string(content), "secret-key") {
+		t.Errorf("Trace file leaks the API key")
+	}
+}
+
+func TestRequestTraceNotSent(t *testing.T) {
+	// A request that fails before it is built has no start time
+	trace := &requestTrace{}
+	trace.finish(nil, errors.New("failed to create request"))
+
+	report := trace.report()
+	if report.TotalMS != nil {
+		t.Errorf("TotalMS = %v, want unset for a request that was never sent", *report.TotalMS)
+	}
+	if report.Error != "failed to create request" {
+		t.Errorf("Error = %q", report.Error)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer token")
+	headers.Set("Proxy-Authorization", "Basic abc")
+	headers.Set("Content-Type", contentTypeJSON)
+
+	redacted := redactHeaders(headers)
+	if redacted.Get("Authorization") != "Bearer ***" {
+		t.Errorf("Authorization = %q", redacted.Get("Authorization"))
+	}
+	if redacted.Get("Proxy-Authorization") != "***" {
+		t.Errorf("Proxy-Authorization = %q", redacted.Get("Proxy-Authorization"))
+	}
+	if redacted.Get("Content-Type") != contentTypeJSON {
+		t.Errorf("Content-Type should be kept")
+	}
+	if headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("redactHeaders must not modify the original headers")
+	}
+}
+
+func TestNilRequestTrace(t *testing.T) {
+	var trace *requestTrace
+	req, _ := http.NewRequest(http.MethodGet, apiEndpoint, nil)
+	if trace.attach(req, 0) != req {
+		t.Errorf("nil trace should return the request unchanged")
+	}
+	trace.response(&http.Response{}, 0)
+	trace.finish(nil, nil)
+	trace.writeDebug(io.Discard)
+	if err := trace.writeTraceFile(filepath.Join(t.TempDir(), "x")); err != nil {
+		t.Errorf("nil trace writeTraceFile() = %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// TLS minimum versions accepted by --tls-min-version
+	tlsVersion12 = "1.2"
+	tlsVersion13 = "1.3"
+
+	// Phase timeout defaults, matching http.DefaultTransport
+	defaultConnectTimeout = 30 * time.Second
+	defaultTLSTimeout     = 10 * time.Second
+)
+
+// httpClient sends API requests to apiURL. Commands replace both from the
+// transport flags by configureTransport.
+var (
+	httpClient = &http.Client{}
+	apiURL     = apiEndpoint
+)
+
+var (
+	flagEndpoint       string
+	flagProxy          string
+	flagCACert         string
+	flagClientCert     string
+	flagClientKey      string
+	flagTLSMinVersion  string
+	flagConnectTimeout time.Duration
+	flagTLSTimeout     time.Duration
+	flagHeaderTimeout  time.Duration
+	flagRecord         string
+	flagReplay         string
+)
+
+// transportOptions configures the HTTP transport used for API requests.
+type transportOptions struct {
+	Proxy          string
+	CACert         string
+	ClientCert     string
+	ClientKey      string
+	TLSMinVersion  string
+	ConnectTimeout time.Duration
+	TLSTimeout     time.Duration
+	HeaderTimeout  time.Duration
+	Record         string
+	Replay         string
+}
+
+// addTransportFlags registers the transport flags on a command that sends
+// API requests.
+func addTransportFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&flagEndpoint, "endpoint", "", "FastGPT API URL (default from KAGI_ENDPOINT, or the Kagi API)")
+	flags.StringVar(&flagProxy, "proxy", "", "Proxy URL (default from HTTPS_PROXY / NO_PROXY)")
+	flags.StringVar(&flagCACert, "ca-cert", "", "PEM file of extra CA certificates to trust")
+	flags.StringVar(&flagClientCert, "client-cert", "", "PEM client certificate for mutual TLS")
+	flags.StringVar(&flagClientKey, "client-key", "", "PEM private key for --client-cert")
+	flags.StringVar(&flagTLSMinVersion, "tls-min-version", tlsVersion12, "Minimum TLS version: 1.2 | 1.3")
+	flags.DurationVar(&flagConnectTimeout, "connect-timeout", defaultConnectTimeout, "Timeout for establishing the TCP connection")
+	flags.DurationVar(&flagTLSTimeout, "tls-timeout", defaultTLSTimeout, "Timeout for the TLS handshake")
+	flags.DurationVar(&flagHeaderTimeout, "header-timeout", 0, "Timeout waiting for response headers after sending the request (0 for none)")
+	flags.StringVar(&flagRecord, "record", "", "Save each request and response as a fixture in this directory")
+	flags.StringVar(&flagReplay, "replay", "", "Answer requests from fixtures in this directory without network access")
+}
+
+// configureTransport replaces httpClient and apiURL with those from the
+// flags.
+func configureTransport() error {
+	endpoint, err := resolveEndpoint(flagEndpoint)
+	if err != nil {
+		return err
+	}
+
+	client, err := newHTTPClient(transportOptions{
+		Proxy:          flagProxy,
+		CACert:         flagCACert,
+		ClientCert:     flagClientCert,
+		ClientKey:      flagClientKey,
+		TLSMinVersion:  flagTLSMinVersion,
+		ConnectTimeout: flagConnectTimeout,
+		TLSTimeout:     flagTLSTimeout,
+		HeaderTimeout:  flagHeaderTimeout,
+		Record:         flagRecord,
+		Replay:         flagReplay,
+	})
+	if err != nil {
+		return err
+	}
+	httpClient = client
+	apiURL = endpoint
+	return nil
+}
+
+// resolveEndpoint returns the API URL; the flag takes precedence over the
+// environment variable.
+func resolveEndpoint(endpoint string) (string, error) {
+	if endpoint == "" {
+		endpoint = os.Getenv(envEndpoint)
+	}
+	if endpoint == "" {
+		return apiEndpoint, nil
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return "", fmt.Errorf("invalid value %q for --endpoint\nExample: --endpoint http://127.0.0.1:8788/api/v0/fastgpt", endpoint)
+	}
+	return endpoint, nil
+}
+
+// newHTTPClient builds a client from opts. The overall request timeout is
+// applied per request by queryKagi, not here.
+func newHTTPClient(opts transportOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid value %q for --proxy\nExample: --proxy http://proxy.example.com:8080", opts.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("invalid value %q for --proxy\nSupported schemes: http, https, socks5, socks5h", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	for name, timeout := range map[string]time.Duration{
+		"connect-timeout": opts.ConnectTimeout,
+		"tls-timeout":     opts.TLSTimeout,
+		"header-timeout":  opts.HeaderTimeout,
+	} {
+		if timeout < 0 {
+			return nil, fmt.Errorf("invalid value %q for --%s\nTimeout must not be negative", timeout, name)
+		}
+	}
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = opts.TLSTimeout
+	transport.ResponseHeaderTimeout = opts.HeaderTimeout
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	switch {
+	case opts.Record != "" && opts.Replay != "":
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
+	case opts.Replay != "":
+		info, err := os.Stat(opts.Replay)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid value %q for --replay\nFixture directory not found", opts.Replay)
+		}
+		return &http.Client{Transport: &replayTransport{dir: opts.Replay}}, nil
+	case opts.Record != "":
+		return &http.Client{Transport: &recordTransport{dir: opts.Record, next: transport}}, nil
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTLSConfig(opts transportOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	switch strings.TrimSpace(opts.TLSMinVersion) {
+	case "", tlsVersion12:
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case tlsVersion13:
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid value %q for --tls-min-version\nValid values: 1.2, 1.3", opts.TLSMinVersion)
+	}
+
+	// Extra CAs are added to the system roots, so public endpoints still
+	// verify when an intercepting proxy is only used for some hosts
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return nil, fmt.Errorf("--client-cert and --client-key must be used together")
+	}
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM writes the server's certificate and key as PEM files.
+func writePEM(t *testing.T, cert tls.Certificate) (certPath, keyPath string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	certPath = filepath.Join(dir, "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath = filepath.Join(dir, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestNewHTTPClientErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    transportOptions
+		wantErr string
+	}{
+		{"proxy without host", transportOptions{Proxy: "proxy.example.com"}, "--proxy"},
+		{"proxy scheme", transportOptions{Proxy: "ftp://proxy.example.com"}, "Supported schemes"},
+		{"tls version", transportOptions{TLSMinVersion: "1.1"}, "--tls-min-version"},
+		{"missing CA file", transportOptions{CACert: missing}, "failed to read CA certificate"},
+		{"CA file without certificates", transportOptions{CACert: notPEM}, "no PEM certificates"},
+		{"cert without key", transportOptions{ClientCert: notPEM}, "must be used together"},
+		{"invalid client cert", transportOptions{ClientCert: notPEM, ClientKey: notPEM}, "failed to load client certificate"},
+		{"negative timeout", transportOptions{ConnectTimeout: -time.Second}, "--connect-timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newHTTPClient(tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newHTTPClient() error = %v; want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientSettings(t *testing.T) {
+	client, err := newHTTPClient(transportOptions{
+		Proxy:         "http://proxy.example.com:8080",
+		TLSMinVersion: tlsVersion13,
+		TLSTimeout:    3 * time.Second,
+		HeaderTimeout: 7 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x; want TLS 1.3", transport.TLSClientConfig.MinVersion)
+	}
+	if transport.TLSHandshakeTimeout != 3*time.Second || transport.ResponseHeaderTimeout != 7*time.Second {
+		t.Errorf("Timeouts = %v, %v", transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, apiEndpoint, nil)
+	proxyURL, err := transport.Proxy(req)
+	if err != nil || proxyURL.String() != "http://proxy.example.com:8080" {
+		t.Errorf("Proxy = %v, %v", proxyURL, err)
+	}
+}
+
+func TestNewHTTPClientCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	certPath, _ := writePEM(t, server.TLS.Certificates[0])
+
+	t.Run("untrusted without CA", func(t *testing.T) {
+		client, err := newHTTPClient(transportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Get(server.URL); err == nil {
+			t.Errorf("Expected certificate verification failure")
+		}
+	})
+
+	t.Run("trusted with CA", func(t *testing.T) {
+		client, err := newHTTPClient(transportOptions{CACert: certPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	})
+}
+
+func TestNewHTTPClientClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// The server's own certificate doubles as the client certificate
+	certPath, keyPath := writePEM(t, server.TLS.Certificates[0])
+
+	client, err := newHTTPClient(transportOptions{CACert: certPath, ClientCert: certPath, ClientKey: keyPath})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d; want 200", resp.StatusCode)
+	}
+}
+
+func TestNewHTTPClientHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := newHTTPClient(transportOptions{HeaderTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Errorf("Expected response header timeout, got %v", err)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	t.Setenv(envEndpoint, "")
+	if endpoint, err := resolveEndpoint(""); err != nil || endpoint != apiEndpoint {
+		t.Errorf("resolveEndpoint(\"\") = %q, %v; want the Kagi API", endpoint, err)
+	}
+
+	t.Setenv(envEndpoint, "http://127.0.0.1:8788/api/v0/fastgpt")
+	if endpoint, _ := resolveEndpoint(""); endpoint != "http://127.0.0.1:8788/api/v0/fastgpt" {
+		t.Errorf("resolveEndpoint should use %s, got %q", envEndpoint, endpoint)
+	}
+	if endpoint, _ := resolveEndpoint("https://gateway.example/api/v0/fastgpt"); endpoint != "https://gateway.example/api/v0/fastgpt" {
+		t.Errorf("The flag should take precedence, got %q", endpoint)
+	}
+
+	for _, invalid := range []string{"127.0.0.1:8788", "ftp://example.com", "http://"} {
+		if _, err := resolveEndpoint(invalid); err == nil {
+			t.Errorf("resolveEndpoint(%q) should fail", invalid)
+		}
+	}
+}
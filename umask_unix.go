@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// withUmask runs fn with the process umask set to mask, so files and sockets
+// it creates never exist with wider permissions.
+func withUmask(mask int, fn func() error) error {
+	previous := syscall.Umask(mask)
+	defer syscall.Umask(previous)
+	return fn()
+}